@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"smart-testify/internal/budget"
+)
+
+// Priorities of the context added to the prompt, lower values are cut last
+const (
+	prioritySignatureType = iota // Receiver, parameter and result types
+	priorityDependency           // Types of the receiver fields whose methods are called
+	priorityCallee               // Functions called in the body
+	priorityBodyType             // Types referenced in the body
+	priorityNestedType           // Types referenced by the definitions of the other types, the deeper ones are cut first
+)
+
+// promptSafetyMargin is the percentage of the limit kept free for the estimation error
+const promptSafetyMargin = 5
+
+var maxPromptTokensFlag int
+
+// contextPlaceholder stands for the context while the prompt is measured without it
+const contextPlaceholder = "\x00context\x00"
+
+// fitContext assembles the context items so that basePrompt plus the copies of the context fit in the model limit
+func fitContext(testFuncName string, items []budget.Item, basePrompt string, copies int) (string, error) {
+	p, err := getProvider()
+	if err != nil {
+		return "", err
+	}
+
+	limits := p.Limits()
+	limit := limits.ContextTokens
+	if maxPromptTokensFlag > 0 {
+		limit = maxPromptTokensFlag
+	}
+
+	estimator := budget.NewEstimator(limits.Model)
+	if limit <= 0 {
+		// The limit of the model is unknown, keep everything
+		return budget.Fit(items, -1, estimator, "\n").Text, nil
+	}
+
+	baseTokens := estimator.Count(basePrompt)
+	available := limit*(100-promptSafetyMargin)/100 - baseTokens
+	if available < 0 {
+		log.Warnf("[%s] Prompt is estimated at %d tokens without any context, which exceeds the limit of %d tokens", testFuncName, baseTokens, limit)
+		available = 0
+	}
+	if copies > 1 {
+		available /= copies
+	} else {
+		copies = 1
+	}
+
+	result := budget.Fit(items, available, estimator, "\n")
+	log.Infof("[%s] Prompt is estimated at %d of %d tokens", testFuncName, baseTokens+result.Tokens*copies, limit)
+	if len(result.Shortened) > 0 {
+		log.Warnf("[%s] Context shortened to signatures to fit the prompt: %v", testFuncName, result.Shortened)
+	}
+	if len(result.Dropped) > 0 {
+		log.Warnf("[%s] Context dropped to fit the prompt: %v", testFuncName, result.Dropped)
+	}
+	return result.Text, nil
+}
+
+// summarizeFunction returns the signature of the function source without its body
+func summarizeFunction(source string) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", "package p\n"+source, 0)
+	if err != nil || len(file.Decls) == 0 {
+		return ""
+	}
+	funcDecl, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok {
+		return ""
+	}
+
+	funcDecl.Body = nil
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, funcDecl); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// summarizeType returns the type definition without struct tags, empty if it can't be shortened
+func summarizeType(source string) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", "package p\n"+source, 0)
+	if err != nil {
+		return ""
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if field, ok := n.(*ast.Field); ok {
+			field.Tag = nil
+		}
+		return true
+	})
+
+	var buf bytes.Buffer
+	for i, decl := range file.Decls {
+		if i > 0 {
+			buf.WriteString("\n\n")
+		}
+		if err := printer.Fprint(&buf, fset, decl); err != nil {
+			return ""
+		}
+	}
+	if buf.Len() >= len(source) {
+		return ""
+	}
+	return buf.String()
+}
+
+// qualifiedName returns name prefixed by its package, if any
+func qualifiedName(packageName, name string) string {
+	if packageName == "" {
+		return name
+	}
+	return packageName + "." + name
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"smart-testify/internal/provider"
+)
+
+// defaultCassettePath is used when --record or --replay is given without a path
+const defaultCassettePath = "smart-testify.cassette.json"
+
+var (
+	recordFlag string
+	replayFlag string
+)
+
+// setupCassette wraps the provider to record responses, or replaces it to replay them
+func setupCassette() error {
+	if recordFlag != "" && replayFlag != "" {
+		return fmt.Errorf("--record and --replay can not be used together")
+	}
+
+	if replayFlag != "" {
+		cassette, err := provider.LoadCassette(replayFlag)
+		if err != nil {
+			return err
+		}
+		if cassette.Len() == 0 {
+			return fmt.Errorf("cassette %s is empty or does not exist, record it first with --record", replayFlag)
+		}
+
+		log.Infof("Replaying %d responses from %s", cassette.Len(), replayFlag)
+		currentProvider = provider.NewReplayer(cassette)
+		return nil
+	}
+
+	if recordFlag != "" {
+		p, err := getProvider()
+		if err != nil {
+			return err
+		}
+		cassette, err := provider.LoadCassette(recordFlag)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Recording responses from %s to %s", p.Name(), recordFlag)
+		currentProvider = provider.NewRecorder(p, cassette)
+	}
+	return nil
+}
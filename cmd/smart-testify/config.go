@@ -7,16 +7,50 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"smart-testify/internal/customhttp"
+	"smart-testify/internal/local"
+	"smart-testify/internal/openai"
+	"smart-testify/internal/provider"
+	"smart-testify/internal/twinkle"
+	"sort"
+	"strings"
+	"text/tabwriter"
 )
 
 // Config struct with the settings
 type Config struct {
-	Model        string `json:"model"`
-	CopilotToken string `json:"copilot_token"`
+	Model            string            `json:"model"`
+	CopilotToken     string            `json:"copilot_token"`
+	OpenAI           openai.Config     `json:"openai"`
+	Local            local.Config      `json:"local"`
+	Twinkle          twinkle.Config    `json:"twinkle"`
+	Custom           customhttp.Config `json:"custom"`
+	Retry            RetryConfig       `json:"retry"`
+	TestNameTemplate string            `json:"test_name_template"` // Empty for the default, see configNamingCmd
+
+	Prompt           string                 `json:"prompt,omitempty"`             // Name of the prompt, the default prompt if empty
+	PromptFile       string                 `json:"prompt_file,omitempty"`        // Path of the prompt file, used instead of Prompt
+	TestFileTemplate string                 `json:"test_file_template,omitempty"` // Path of the test file template
+	Include          []string               `json:"include,omitempty"`            // Globs of the files to generate for, relative to Root
+	Exclude          []string               `json:"exclude,omitempty"`            // Globs of the files to skip, relative to Root
+	Generate         map[string]interface{} `json:"generate,omitempty"`           // Values of the generate flags, keyed by flag name
+
+	// Root is the directory of the repository config, or the current directory without one
+	Root string `json:"-"`
+}
+
+// RetryConfig controls how failed model calls are retried
+type RetryConfig struct {
+	MaxAttempts           int `json:"max_attempts"`            // Total number of attempts per call, 0 means the default
+	RequestTimeoutSeconds int `json:"request_timeout_seconds"` // Timeout of each attempt, 0 means the default
 }
 
 const modelCopilot = "copilot"
 const modelTwinkle = "twinkle"
+const modelOpenAI = "openai"
+const modelLocal = "local"
+const modelCustom = "custom"
 
 // configCmd is the root command for configuration
 var configCmd = &cobra.Command{
@@ -27,16 +61,13 @@ var configCmd = &cobra.Command{
 // configUseCmd sets the model to be used for test case generation
 var configUseCmd = &cobra.Command{
 	Use:   "use",
-	Short: "Set the model to be used for test case generation (copilot or twinkle), by default it is set to twinkle.",
+	Short: "Set the model to be used for test case generation (any registered provider, e.g. copilot or twinkle), by default it is set to twinkle.",
 	Args:  cobra.ExactArgs(1), // Ensure exactly one argument is provided
 	Run: func(cmd *cobra.Command, args []string) {
 		// Validate and set model
 		model := args[0]
-		if model != modelCopilot && model != modelTwinkle {
-			log.Errorf("Invalid model: %s, must be one of %v", model, []string{
-				modelCopilot,
-				modelTwinkle,
-			})
+		if !provider.IsRegistered(model) {
+			log.Errorf("Invalid model: %s, must be one of %v", model, provider.Names())
 			return
 		}
 
@@ -57,14 +88,29 @@ var configUseCmd = &cobra.Command{
 		if config.Model == modelCopilot && config.CopilotToken == "" {
 			log.Warn("Copilot token is not set. You must init the Copilot token manually before using it. Please run `smart-testify config copilot init-token` and follow the instructions.")
 		}
+		if config.Model == modelTwinkle && config.Twinkle.Endpoint == "" {
+			log.Warn("Twinkle endpoint is not set. Please run `smart-testify config twinkle set-endpoint <url>` before using it.")
+		}
+		if config.Model == modelOpenAI && config.OpenAI.Model == "" {
+			log.Warn("OpenAI model is not set. Please run `smart-testify config openai set --base-url <url> --api-key <key> --model <name>` before using it.")
+		}
 	},
 }
 
 // configShowCmd displays the current configuration
 var configShowCmd = &cobra.Command{
-	Use:   "show",
-	Short: "Display the current configuration settings",
+	Use:   "show [path]",
+	Short: "Display the current configuration settings, or with --effective the settings in effect for the path and their source",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if showEffectiveFlag {
+			if len(args) > 0 {
+				configSearchDir = args[0]
+			}
+			showEffectiveConfig()
+			return
+		}
+
 		// Load the configuration
 		config, err := loadConfig()
 		if err != nil {
@@ -76,13 +122,59 @@ var configShowCmd = &cobra.Command{
 		log.Println("Current Configuration:")
 		log.Printf("\tModel: %s\n", config.Model)
 		if config.CopilotToken != "" {
-			log.Printf("\tCopilot Token: %s\n", config.CopilotToken)
+			log.Printf("\tCopilot Token: %s\n", maskSecret(config.CopilotToken))
 		} else {
 			log.Println("\tCopilot Token: Not set")
 		}
+		if config.Twinkle.Endpoint != "" {
+			log.Printf("\tTwinkle Endpoint: %s\n", config.Twinkle.Endpoint)
+		} else {
+			log.Println("\tTwinkle Endpoint: Not set")
+		}
+		log.Printf("\tTwinkle Auth: %s (header %s)\n", maskSecret(config.Twinkle.AuthToken), config.Twinkle.AuthHeader)
+		log.Printf("\tTwinkle Timeout: %d seconds\n", config.Twinkle.TimeoutSeconds)
+		// The extra headers often carry API keys, they are masked like the auth token
+		headerNames := make([]string, 0, len(config.Twinkle.Headers))
+		for name := range config.Twinkle.Headers {
+			headerNames = append(headerNames, name)
+		}
+		sort.Strings(headerNames)
+		for _, name := range headerNames {
+			log.Printf("\tTwinkle Header: %s: %s\n", name, maskSecret(config.Twinkle.Headers[name]))
+		}
+		log.Printf("\tTest Name Template: %s\n", effectiveTestNameTemplate(config))
+		log.Printf("\tRetry Max Attempts: %d\n", config.Retry.MaxAttempts)
+		log.Printf("\tRetry Request Timeout: %d seconds\n", config.Retry.RequestTimeoutSeconds)
+		log.Printf("\tOpenAI Base URL: %s\n", config.OpenAI.BaseURL)
+		log.Printf("\tOpenAI API Key: %s\n", maskSecret(config.OpenAI.APIKey))
+		log.Printf("\tOpenAI Model: %s\n", config.OpenAI.Model)
+		log.Printf("\tOpenAI Temperature: %v\n", config.OpenAI.Temperature)
+		log.Printf("\tOpenAI Max Tokens: %d\n", config.OpenAI.MaxTokens)
+		log.Printf("\tOpenAI Context Tokens: %d\n", config.OpenAI.ContextTokens)
+		log.Printf("\tOpenAI Stream: %v\n", config.OpenAI.Stream)
+		log.Printf("\tLocal Backend: %s\n", config.Local.Backend)
+		log.Printf("\tLocal Endpoint: %s\n", config.Local.Endpoint)
+		log.Printf("\tLocal Model: %s\n", config.Local.Model)
+		log.Printf("\tLocal Context Size: %d\n", config.Local.ContextSize)
+		log.Printf("\tCustom URL: %s\n", config.Custom.URL)
+		log.Printf("\tCustom Model: %s\n", config.Custom.Model)
+		log.Printf("\tCustom Body Template: %s\n", config.Custom.BodyTemplate)
+		log.Printf("\tCustom Response Path: %s\n", config.Custom.ResponsePath)
+		log.Printf("\tCustom Stream: %v (event path %s)\n", config.Custom.Stream, config.Custom.EventPath)
 	},
 }
 
+// maskSecret hides most of a secret so it can be displayed safely
+func maskSecret(secret string) string {
+	if secret == "" {
+		return "Not set"
+	}
+	if len(secret) <= 8 {
+		return "********"
+	}
+	return secret[:4] + "********" + secret[len(secret)-4:]
+}
+
 func getConfigPath() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -139,23 +231,118 @@ func loadConfig() (*Config, error) {
 	return &config, nil
 }
 
+var showEffectiveFlag bool
+
+// secretKeys are masked by config show --effective
+var secretKeys = map[string]bool{
+	"copilot_token":      true,
+	"openai.api_key":     true,
+	"twinkle.auth_token": true,
+}
+
+// secretPrefixes are the maps of headers masked by config show --effective, their values often carry API keys
+var secretPrefixes = []string{"twinkle.headers.", "custom.headers."}
+
+// isSecretKey reports whether the value of the flattened key must be masked
+func isSecretKey(key string) bool {
+	if secretKeys[key] {
+		return true
+	}
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// showEffectiveConfig prints the settings in effect and where they come from
+func showEffectiveConfig() {
+	_, merged, sources, err := loadEffectiveConfig()
+	if err != nil {
+		log.Errorf("Failed to load config: %v", err)
+		return
+	}
+
+	leaves := make(map[string]interface{})
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, key := range flattenConfig(merged, "", leaves) {
+		value := leaves[key]
+		if value == nil || reflect.ValueOf(value).IsZero() {
+			continue
+		}
+		text := fmt.Sprint(value)
+		if list, ok := value.([]interface{}); ok {
+			if len(list) == 0 {
+				continue
+			}
+			text = strings.Trim(fmt.Sprint(list), "[]")
+		}
+		if isSecretKey(key) {
+			text = maskSecret(text)
+		}
+		fmt.Fprintf(w, "%s:\t%s\t(%s)\n", key, text, sources[key])
+	}
+	w.Flush()
+	fmt.Println("The flags given to generate override the generate.* settings.")
+}
+
 func init() {
+	configShowCmd.Flags().BoolVar(&showEffectiveFlag, "effective", false, "Show the settings in effect, merged from the global config, the repository config and the environment, with their source")
+
 	// Add the 'use', 'show' subcommands to the 'config' command
 	configCmd.AddCommand(configShowCmd) // Add the new 'show' subcommand
 	configCmd.AddCommand(configUseCmd)
 	configCmd.AddCommand(promptCmd)
 	configCmd.AddCommand(copilotCmd)
+	configCmd.AddCommand(twinkleCmd)
+	configCmd.AddCommand(openaiCmd)
+	configCmd.AddCommand(localCmd)
+	configCmd.AddCommand(customCmd)
+	configCmd.AddCommand(configNamingCmd)
+	configCmd.AddCommand(configTestFileCmd)
 }
 
-var globalConfig *Config
+var (
+	effectiveConfig *Config
+	configSources   map[string]string
+)
 
-func getGlobalConfig() *Config {
-	if globalConfig == nil {
+// getEffectiveConfig returns the config in effect: the global config, with the repository config
+// and the environment variables layered over it
+func getEffectiveConfig() *Config {
+	if effectiveConfig == nil {
 		var err error
-		globalConfig, err = loadConfig()
+		effectiveConfig, _, configSources, err = loadEffectiveConfig()
 		if err != nil {
-			log.Fatal("Failed to load config: %v", err)
+			log.Fatalf("Failed to load config: %v", err)
+		}
+	}
+	return effectiveConfig
+}
+
+// loadEffectiveConfig merges the config layers, it returns the merged values and where each one comes from
+func loadEffectiveConfig() (*Config, map[string]interface{}, map[string]string, error) {
+	layers, repoRoot, err := loadConfigLayers()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	merged, sources := mergeConfigLayers(layers)
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to marshal config: %v", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	config.Root = repoRoot
+	if config.Root == "" {
+		if config.Root, err = os.Getwd(); err != nil {
+			return nil, nil, nil, err
 		}
 	}
-	return globalConfig
+	return &config, merged, sources, nil
 }
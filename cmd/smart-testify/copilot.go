@@ -10,7 +10,7 @@ var copilotClient *copilot.Client
 
 func getCopilotClient() *copilot.Client {
 	if copilotClient == nil {
-		copilotClient = copilot.NewCopilotClient(getGlobalConfig().CopilotToken, false)
+		copilotClient = copilot.NewCopilotClient(getEffectiveConfig().CopilotToken, false)
 	}
 
 	return copilotClient
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"smart-testify/internal/coverage"
+	"smart-testify/internal/util"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultCoverageRounds = 3
+
+var (
+	coverageTargetFlag float64
+	coverageRoundsFlag int
+)
+
+// generatedTests counts the generated test functions, a coverage round without any means the target is reached
+var generatedTests int64
+
+// coverageProfiles caches the profile of each package during a round, keyed by directory
+var coverageProfiles sync.Map
+
+type packageCoverage struct {
+	once    sync.Once
+	profile *coverage.Profile
+	err     error
+}
+
+// resetCoverage drops the cached profiles so they are measured again, and returns the measured directories
+func resetCoverage() []string {
+	var dirs []string
+	coverageProfiles.Range(func(key, _ interface{}) bool {
+		dirs = append(dirs, key.(string))
+		coverageProfiles.Delete(key)
+		return true
+	})
+	sort.Strings(dirs)
+	return dirs
+}
+
+// packageCoverageProfile returns the profile of the package in dir, it is measured once per round
+func packageCoverageProfile(ctx context.Context, dir string) (*coverage.Profile, error) {
+	entry, _ := coverageProfiles.LoadOrStore(dir, &packageCoverage{})
+	pc := entry.(*packageCoverage)
+	pc.once.Do(func() {
+		pc.profile, pc.err = measureCoverage(ctx, dir)
+	})
+	return pc.profile, pc.err
+}
+
+// measureCoverage runs the tests of the package in dir with go test -coverprofile
+func measureCoverage(ctx context.Context, dir string) (*coverage.Profile, error) {
+	unlock := lockPackage(dir)
+	defer unlock()
+
+	profileFile, err := ioutil.TempFile("", "smart-testify-*.coverprofile")
+	if err != nil {
+		return nil, err
+	}
+	profileFile.Close()
+	defer os.Remove(profileFile.Name())
+
+	output, testErr := util.RunGoCommand(ctx, dir, "test", "-count=1", "-coverprofile="+profileFile.Name(), ".")
+	profile, err := coverage.ParseProfileFile(profileFile.Name())
+	if testErr != nil {
+		if err != nil || len(profile.Blocks) == 0 {
+			return nil, fmt.Errorf("go test -coverprofile failed: %v\n%s", testErr, output)
+		}
+		log.Warnf("Some tests of %s fail, the coverage may be lower than expected:\n%s", dir, output)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []coverage.Block
+	for _, fileBlocks := range profile.Blocks {
+		blocks = append(blocks, fileBlocks...)
+	}
+	log.Infof("Coverage of %s: %.1f%%", dir, coverage.Percent(blocks))
+	return profile, nil
+}
+
+// reportCoverage measures the coverage of the packages again after the last round
+func reportCoverage(ctx context.Context) {
+	for _, dir := range resetCoverage() {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := packageCoverageProfile(ctx, dir); err != nil {
+			log.Warnf("Failed to measure coverage of %s: %v", dir, err)
+		}
+	}
+}
+
+// describeUncovered lists the uncovered lines of the function for the prompt
+func describeUncovered(fset *token.FileSet, method *ast.FuncDecl, funcCoverage coverage.Func) string {
+	if len(funcCoverage.Uncovered) == 0 {
+		return "The existing tests don't cover this function yet.\n"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The existing tests cover %.1f%% of the statements of this function. The test cases should cover the following lines, which are not covered yet:\n",
+		funcCoverage.Percent())
+	for _, block := range funcCoverage.Uncovered {
+		fmt.Fprintf(&sb, "- %s\n", coverage.Describe(fset, method, block))
+	}
+	return sb.String()
+}
+
+// uniqueTestFuncName suffixes the name so it doesn't replace an existing test function
+func uniqueTestFuncName(name string, existingTests map[string]*ast.FuncDecl) string {
+	candidate := name + "_Coverage"
+	for i := 2; ; i++ {
+		if _, exists := existingTests[candidate]; !exists {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_Coverage%d", name, i)
+	}
+}
+
+func countGeneratedTests(n int) {
+	atomic.AddInt64(&generatedTests, int64(n))
+}
+
+func generatedTestCount() int64 {
+	return atomic.LoadInt64(&generatedTests)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	customURL            string
+	customMethod         string
+	customModel          string
+	customHeaders        []string
+	customBodyTemplate   string
+	customResponsePath   string
+	customErrorPath      string
+	customStream         bool
+	customEventPath      string
+	customDoneData       string
+	customTimeoutSeconds int
+	customContextTokens  int
+)
+
+var customCmd = &cobra.Command{
+	Use:   "custom",
+	Short: "Configure a custom HTTP gateway described by request templates and JSON paths.",
+}
+
+var customSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set how requests are built and how completions are extracted, only the given flags are updated",
+	Example: `  smart-testify config custom set --url https://gateway.example.com/v1/generate \
+    --header 'Authorization=Bearer {{env "GATEWAY_TOKEN"}}' \
+    --body-template '{"model": "{{.Model}}", "input": "{{.Prompt}}"}' \
+    --response-path 'data.outputs[0].text' --error-path 'error.message'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			log.Errorf("Failed to load config: %v", err)
+			return
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("header") {
+			headers := make(map[string]string, len(customHeaders))
+			for _, header := range customHeaders {
+				parts := strings.SplitN(header, "=", 2)
+				if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+					log.Errorf("Invalid header: %s, must be in format Name=Template", header)
+					return
+				}
+				headers[strings.TrimSpace(parts[0])] = parts[1]
+			}
+			config.Custom.Headers = headers
+		}
+		if flags.Changed("url") {
+			config.Custom.URL = customURL
+		}
+		if flags.Changed("method") {
+			config.Custom.Method = strings.ToUpper(customMethod)
+		}
+		if flags.Changed("model") {
+			config.Custom.Model = customModel
+		}
+		if flags.Changed("body-template") {
+			config.Custom.BodyTemplate = customBodyTemplate
+		}
+		if flags.Changed("response-path") {
+			config.Custom.ResponsePath = customResponsePath
+		}
+		if flags.Changed("error-path") {
+			config.Custom.ErrorPath = customErrorPath
+		}
+		if flags.Changed("stream") {
+			config.Custom.Stream = customStream
+		}
+		if flags.Changed("event-path") {
+			config.Custom.EventPath = customEventPath
+		}
+		if flags.Changed("done-data") {
+			config.Custom.DoneData = customDoneData
+		}
+		if flags.Changed("timeout") {
+			config.Custom.TimeoutSeconds = customTimeoutSeconds
+		}
+		if flags.Changed("context-tokens") {
+			config.Custom.ContextTokens = customContextTokens
+		}
+
+		if err := saveConfig(config); err != nil {
+			log.Errorf("Failed to save config: %v", err)
+			return
+		}
+		fmt.Println("Config updated: custom provider settings saved")
+	},
+}
+
+func init() {
+	customSetCmd.Flags().StringVar(&customURL, "url", "", "URL the request is sent to")
+	customSetCmd.Flags().StringVar(&customMethod, "method", "", "HTTP method, defaults to POST")
+	customSetCmd.Flags().StringVar(&customModel, "model", "", "Model name available as {{.Model}} in the templates")
+	customSetCmd.Flags().StringArrayVar(&customHeaders, "header", nil, "Header in format Name=Template, can be repeated. Replaces all configured headers")
+	customSetCmd.Flags().StringVar(&customBodyTemplate, "body-template", "", "Go template of the JSON request body, {{.Prompt}} and {{.Model}} are escaped for JSON strings")
+	customSetCmd.Flags().StringVar(&customResponsePath, "response-path", "", "JSON path of the completion in the response, e.g. choices[0].message.content")
+	customSetCmd.Flags().StringVar(&customErrorPath, "error-path", "", "JSON path of an error message in the response, e.g. error.message")
+	customSetCmd.Flags().BoolVar(&customStream, "stream", false, "Whether the response is sent as server-sent events")
+	customSetCmd.Flags().StringVar(&customEventPath, "event-path", "", "JSON path of the completion chunk in each event, e.g. choices[0].delta.content")
+	customSetCmd.Flags().StringVar(&customDoneData, "done-data", "", "Event data that ends the stream, e.g. [DONE]")
+	customSetCmd.Flags().IntVar(&customTimeoutSeconds, "timeout", 0, "Timeout of each request in seconds, 0 means the default of 120 seconds")
+	customSetCmd.Flags().IntVar(&customContextTokens, "context-tokens", 0, "Context window of the model in tokens, 0 means unknown")
+
+	customCmd.AddCommand(customSetCmd)
+}
@@ -1,22 +1,26 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"go/ast"
-	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
-	"smart-testify/internal/twinkle"
+	"smart-testify/internal/budget"
+	"smart-testify/internal/coverage"
+	"smart-testify/internal/provider"
 	"smart-testify/internal/util"
 	"sort"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -26,11 +30,13 @@ var (
 	filter          string
 	ignoreErrorFlag bool
 	granularity     string
+	liveFlag        bool
 )
 
 const (
-	modeSkip   = "skip"
-	modeAppend = "append"
+	modeSkip    = "skip"
+	modeAppend  = "append"
+	modeReplace = "replace"
 
 	granularityFile     = "file"
 	granularityFunction = "function"
@@ -74,38 +80,183 @@ var generateCmd = &cobra.Command{
 			return
 		}
 
+		// The repository config is looked up from the first path, its generate settings apply
+		// unless the flag is given on the command line
+		configSearchDir = validPaths[0]
+		if err := applyGenerateConfig(cmd); err != nil {
+			log.Errorf("Failed to apply config: %v", err)
+			return
+		}
+
+		// Keep the standard output for the generated files and diffs
+		if dryRunFlag || diffFlag {
+			log.SetOutput(os.Stderr)
+		}
+
+		if contextDepthFlag < 0 {
+			log.Errorf("Invalid --context-depth %d, it must be 0 or more", contextDepthFlag)
+			return
+		}
+
+		if modeFlag != modeSkip && modeFlag != modeAppend && modeFlag != modeReplace {
+			log.Errorf("Invalid --mode %q, possible values: %s, %s, %s", modeFlag, modeSkip, modeAppend, modeReplace)
+			return
+		}
+
+		if onFailureFlag != onFailureDrop && onFailureFlag != onFailureQuarantine {
+			log.Errorf("Invalid --on-failure %q, possible values: %s, %s", onFailureFlag, onFailureDrop, onFailureQuarantine)
+			return
+		}
+
+		if err := setupCassette(); err != nil {
+			log.Errorf("Failed to set up cassette: %v", err)
+			return
+		}
+
+		// Fail early when the selected provider is not configured
+		p, err := getProvider()
+		if err != nil {
+			log.Errorf("Failed to initialize provider: %v", err)
+			return
+		}
+
+		if liveFlag && jobsFlag > 1 {
+			log.Warnf("--live is ignored when --jobs is greater than 1, the responses would be interleaved")
+			liveFlag = false
+		}
+		if liveFlag {
+			if streamer, ok := p.(provider.Streamer); ok && p.Capabilities().Streaming {
+				streamer.SetTokenHandler(func(token string) {
+					fmt.Fprint(os.Stderr, token)
+				})
+			} else {
+				log.Warnf("Provider %s does not stream its responses, --live is ignored", p.Name())
+			}
+		}
+
 		log.Infof("Mode: %s", modeFlag)
 		log.Infof("Function Filter: %s", filter)
 		log.Infof("Ignore Error: %v", ignoreErrorFlag)
 		log.Infof("Granularity: %s", granularity)
+		log.Infof("Jobs: %d", jobsFlag)
+		if coverageTargetFlag > 0 {
+			log.Infof("Coverage Target: %.1f%%, up to %d rounds", coverageTargetFlag, coverageRoundsFlag)
+		}
+		if verifyFlag {
+			log.Infof("Verify: max %d repair attempts, on failure: %s", maxRepairAttemptsFlag, onFailureFlag)
+		}
 
-		// Step 2: Process valid paths
-		for _, path := range validPaths {
-			fileInfo, _ := os.Stat(path) // No need to check error again, already validated
+		defer reportRetryStats()
 
-			log.Infof("Processing Path: %s", path)
+		// Ctrl-C stops scheduling new work and cancels the model calls in flight, the files that are not complete are left untouched
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		initJobSlots(jobsFlag)
 
-			if fileInfo.IsDir() {
-				// Process directory
-				if err := processDirectory(path); err != nil {
-					log.Errorf("Failed to process directory '%s': %v", path, err)
-				}
-			} else {
-				// Process Go file
-				if err := processFile(path); err != nil {
-					log.Errorf("Failed to process file '%s': %v", path, err)
-				}
+		// Step 2: Process valid paths, again while some functions are below the coverage target
+		rounds := 1
+		if coverageTargetFlag > 0 {
+			rounds = coverageRoundsFlag
+			if !writesInPlace() && rounds > 1 {
+				log.Warnf("The test files are not written in place, --coverage-target only runs one round")
+				rounds = 1
 			}
 		}
+		for round := 1; round <= rounds; round++ {
+			if coverageTargetFlag > 0 {
+				log.Infof("Coverage round %d of %d, target: %.1f%%", round, rounds, coverageTargetFlag)
+				resetCoverage()
+			}
+
+			generated := generatedTestCount()
+			if !processPaths(ctx, validPaths) {
+				return
+			}
+			if coverageTargetFlag > 0 && generatedTestCount() == generated {
+				log.Infof("No function below the coverage target left")
+				break
+			}
+		}
+		flushAllMocks()
+		if coverageTargetFlag > 0 {
+			reportCoverage(ctx)
+		}
 	},
 }
 
-func processDirectory(path string) error {
-	return filepath.Walk(path, func(filePath string, info fs.FileInfo, err error) error {
+// processPaths processes the files and directories, it returns false if the generation was cancelled
+func processPaths(ctx context.Context, paths []string) bool {
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			log.Warnf("Generation cancelled, skipping remaining paths")
+			return false
+		}
+
+		fileInfo, _ := os.Stat(path) // No need to check error again, already validated
+
+		log.Infof("Processing Path: %s", path)
+
+		if fileInfo.IsDir() {
+			// Process directory
+			if err := processDirectory(ctx, path); err != nil {
+				log.Errorf("Failed to process directory '%s': %v", path, err)
+			}
+		} else {
+			// Process Go file
+			if err := processFile(ctx, path); err != nil {
+				log.Errorf("Failed to process file '%s': %v", path, err)
+			}
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// processDirectory processes the Go files of the directory concurrently, up to --jobs at a time
+func processDirectory(ctx context.Context, path string) error {
+	filePaths, err := collectGoFiles(path)
+	if err != nil {
+		return err
+	}
+
+	g := newTaskGroup(ctx)
+	fileSlots := make(chan struct{}, cap(jobSlots))
+	for _, filePath := range filePaths {
+		filePath := filePath
+		select {
+		case fileSlots <- struct{}{}:
+		case <-g.ctx.Done():
+		}
+		if g.ctx.Err() != nil {
+			break
+		}
+
+		g.Go(func(ctx context.Context) error {
+			defer func() { <-fileSlots }()
+
+			if err := processFile(ctx, filePath); err != nil {
+				log.Errorf("Failed to process file: %v", err)
+				if !ignoreErrorFlag || ctx.Err() != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// collectGoFiles returns the Go files under path, in walk order, that pass the file filter
+func collectGoFiles(path string) ([]string, error) {
+	var filePaths []string
+	err := filepath.Walk(path, func(filePath string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if !info.IsDir() && strings.HasSuffix(filePath, ".go") && !strings.HasSuffix(filePath, "_test.go") {
+			if !includedByConfig(filePath) {
+				log.Infof("Skipping file %s due to the include and exclude globs of the config", filePath)
+				return nil
+			}
 			if filter != "" && granularity == granularityFile {
 				// get file name from filePath
 				_, fileName := filepath.Split(filePath)
@@ -119,18 +270,14 @@ func processDirectory(path string) error {
 					return nil
 				}
 			}
-			if err := processFile(filePath); err != nil {
-				log.Errorf("Failed to process file: %v", err)
-				if !ignoreErrorFlag {
-					return err
-				}
-			}
+			filePaths = append(filePaths, filePath)
 		}
 		return nil
 	})
+	return filePaths, err
 }
 
-func processFile(filePath string) error {
+func processFile(ctx context.Context, filePath string) error {
 	log.Infof("Starting to process file: %s", filePath)
 	defer log.Infof("Finished processing file: %s", filePath)
 
@@ -167,8 +314,26 @@ func processFile(filePath string) error {
 
 	}
 
-	// Initialize final test code which will hold the generated or modified test code
-	var generatedTestCode string
+	// Coverage of the functions of the file, measured with the tests of the package
+	var fileBlocks []coverage.Block
+	if coverageTargetFlag > 0 {
+		profile, err := packageCoverageProfile(ctx, filepath.Dir(filePath))
+		if err != nil {
+			return fmt.Errorf("Failed to measure coverage of %s: %v", filePath, err)
+		}
+		fileBlocks = profile.FileBlocks(filePath)
+		if fileBlocks == nil {
+			log.Infof("%s is not in the coverage profile, its functions are treated as uncovered", filePath)
+		}
+	}
+
+	// Methods to generate test cases for, with the name of their test function
+	type methodJob struct {
+		method       *ast.FuncDecl
+		testFuncName string
+		coverageHint string
+	}
+	var jobs []methodJob
 
 	// Process each method and decide if we need to generate or skip test cases
 	for _, method := range methods {
@@ -178,8 +343,9 @@ func processFile(filePath string) error {
 			return fmt.Errorf("Failed to generate test function name: %v", err)
 		}
 
-		// Check if a test function already exists for this method
-		if _, exists := existingTests[testFuncName]; exists {
+		// Check if a test function already exists for this method, following any common naming convention
+		if existingName, exists := findExistingTest(method, testFuncName, existingTests); exists {
+			testFuncName = existingName
 			log.Infof("[%s] Test function already exists", testFuncName)
 
 			// If mode is skip, skip generating the test case for this method
@@ -188,30 +354,86 @@ func processFile(filePath string) error {
 				continue
 			}
 
-			// If mode is append, delete the existing test method from the AST
-			if modeFlag == modeAppend && granularity == granularityFunction {
-				// Remove the old test function from the AST
-				// This is achieved by filtering out the existing test function from the declarations
-				//modifiedTestCode = removeTestFunction(modifiedTestCode, existingTest)
+			// If mode is append, the generated cases are merged into the existing test function as subtests
+			if modeFlag == modeAppend {
 				log.Infof("[%s] Append more cases", testFuncName)
 			}
-		}
 
-		log.Infof("[%s] Start to generating test cases", testFuncName)
+			// If mode is replace, the existing test function is replaced by the generated one
+			if modeFlag == modeReplace {
+				log.Infof("[%s] Replace the test function", testFuncName)
+			}
+		}
 
-		testMethodSourceCode, err := generateTestCases(sourceFileSet, []*ast.FuncDecl{method}, filePath)
-		if err != nil {
-			return fmt.Errorf("Failed to generate test cases for method %s: %v", method.Name.Name, err)
+		// Only generate for the functions below the coverage target, focusing on the uncovered lines
+		var coverageHint string
+		if coverageTargetFlag > 0 {
+			funcCoverage := coverage.ForFunc(sourceFileSet, method, fileBlocks)
+			// A measured file has no block for the functions without statements, there is nothing to cover
+			if fileBlocks != nil && len(funcCoverage.Blocks) == 0 {
+				log.Infof("[%s] No statements to cover, skipping", testFuncName)
+				continue
+			}
+			if funcCoverage.Percent() >= coverageTargetFlag {
+				log.Infof("[%s] Coverage %.1f%% reaches the target, skipping", testFuncName, funcCoverage.Percent())
+				continue
+			}
+			coverageHint = describeUncovered(sourceFileSet, method, funcCoverage)
+			// Don't replace the test function which covers the other lines
+			if _, exists := existingTests[testFuncName]; exists && modeFlag == modeReplace {
+				testFuncName = uniqueTestFuncName(testFuncName, existingTests)
+			}
 		}
-		generatedTestCode += testMethodSourceCode
+
+		jobs = append(jobs, methodJob{method: method, testFuncName: testFuncName, coverageHint: coverageHint})
+	}
+
+	// Generate the test cases concurrently, the results keep the order of the methods
+	existingTestNames := sortedTestNames(existingTests)
+	results := make([]generatedTest, len(jobs))
+	g := newTaskGroup(ctx)
+	for i, job := range jobs {
+		i, job := i, job
+		g.Go(func(ctx context.Context) error {
+			if err := acquireJobSlot(ctx); err != nil {
+				return err
+			}
+			defer releaseJobSlot()
+
+			log.Infof("[%s] Start to generating test cases", job.testFuncName)
+			// The model call is cancelled with the context, so the worker returns before the group stops waiting
+			test, err := generateTestCase(ctx, sourceFileSet, job.method, filePath, job.testFuncName, job.coverageHint, existingTestNames)
+			if err != nil {
+				return fmt.Errorf("Failed to generate test cases for method %s: %v", job.method.Name.Name, err)
+			}
+			test.TestFuncName = job.testFuncName
+			results[i] = test
+			return nil
+		})
 	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	countGeneratedTests(len(results))
 
-	if generatedTestCode == "" {
+	if len(results) == 0 {
 		log.Infof("No test cases generated for file %s", filePath)
 		return nil
 	}
 
+	// The mocks the test functions use are written first, they are locked separately from the test file
+	if writesInPlace() {
+		if err := flushMocks(filepath.Dir(filePath)); err != nil {
+			return err
+		}
+	}
+
+	// Serialize the read-modify-write of the test file
+	unlock := lockTestFile(testFilePath)
+	defer unlock()
+
 	// Generate the modified test file content by modifying the AST
+	testFileExists = fileExists(testFilePath)
 	var originalTestFileCode string
 	if testFileExists {
 		// just load the existing test file content from testFilePath
@@ -223,40 +445,37 @@ func processFile(filePath string) error {
 		originalTestFileCode = string(testSourceFile) + "\n"
 
 	} else {
-		originalTestFileCode = defaultTestFile(node.Name.Name)
+		generatedCode := make([]string, len(results))
+		for i, test := range results {
+			generatedCode[i] = test.Code
+		}
+		originalTestFileCode, err = newTestFile(filePath, node, generatedCode)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Append the generated test code to the existing test file code
-	originalTestFileCode += generatedTestCode
+	// Append the generated test code to the existing test file code, checking that each test
+	// builds and passes first if verification is enabled
+	if verifyFlag {
+		originalTestFileCode, err = verifyGeneratedTests(ctx, testFilePath, node.Name.Name, originalTestFileCode, results)
+		if err != nil {
+			return fmt.Errorf("Failed to verify the tests generated for %s: %v", filePath, err)
+		}
+	} else {
+		for _, test := range results {
+			originalTestFileCode = appendTestCode(originalTestFileCode, test.Code)
+		}
+	}
 
 	// Write the final generated code to the test file
-	if err := writeTestFile(testFilePath, originalTestFileCode); err != nil {
+	if err := emitTestFile(testFilePath, originalTestFileCode); err != nil {
 		return fmt.Errorf("Failed to write to test file %s: %v", testFilePath, err)
 	}
 
-	if err := util.RunGoImports(testFilePath); err != nil {
-		log.Warnf("Failed to run goimports for %s due to %s", testFilePath, err)
-	}
-
 	return nil
 }
 
-func defaultTestFile(packageName string) string {
-	return fmt.Sprintf(`
-// Code generated by AI.
-package %s
-
-import (
-	"testing"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
-	"gorm.io/gorm/schema"
-	"github.com/volatiletech/null/v9"
-)
-
-`, packageName)
-}
-
 // writeTestFile writes the modified test code to the test file
 func writeTestFile(testFilePath, finalCode string) error {
 	// If the file already exists, overwrite it
@@ -288,28 +507,6 @@ func parseTestFile(filePath string) (*ast.File, map[string]*ast.FuncDecl, error)
 	return node, existingTests, nil
 }
 
-// generateTestFuncName generates the test function name based on receiver type and method name.
-func generateTestFuncName(method *ast.FuncDecl) (string, error) {
-	// Keep original method name to preserve case
-	methodName := method.Name.Name
-
-	if method.Recv != nil && len(method.Recv.List) > 0 {
-		// Extract the receiver type name
-		pair, err := parseTypeDefination(method.Recv.List[0].Type)
-		if err != nil {
-			return "", err
-		}
-		if len(pair) == 0 {
-			return "", fmt.Errorf("receiver type not found")
-		}
-
-		// Generate test function name: Test_[Receiver]_[Method]
-		return "Test_" + pair[0].TypeName + "_" + methodName, nil
-	}
-	// For regular functions: Test_[Method]
-	return "Test_" + methodName, nil
-}
-
 // Check if a file exists
 func fileExists(filePath string) bool {
 	_, err := os.Stat(filePath)
@@ -323,41 +520,52 @@ func parseGoFile(filePath string) (*token.FileSet, *ast.File, error) {
 	return fset, node, err
 }
 
-// Generate test cases for each method
-func generateTestCases(fset *token.FileSet, methods []*ast.FuncDecl, filePath string) (string, error) {
-	var testCode string
-	for _, method := range methods {
-		prompt, err := generatePrompt(fset, method, filePath)
-		if err != nil {
-			return "", fmt.Errorf("Failed to generate prompt: %s", err.Error())
-		}
+// generatedTest is a test function generated by the model, with the prompt it was generated from
+type generatedTest struct {
+	TestFuncName string
+	Prompt       string
+	Code         string
+}
 
-		log.Infof("Prompt for method %s: %s", method.Name.Name, prompt)
+// generateTestCase builds the prompt of the method and asks the model for its test function
+func generateTestCase(ctx context.Context, fset *token.FileSet, method *ast.FuncDecl, filePath, testFuncName, coverageHint string, existingTests []string) (generatedTest, error) {
+	prompt, err := generatePrompt(fset, method, filePath, testFuncName, coverageHint, existingTests)
+	if err != nil {
+		return generatedTest{}, fmt.Errorf("Failed to generate prompt: %s", err.Error())
+	}
 
-		var resp string
-		if getGlobalConfig().Model == modelCopilot {
-			log.Infof("Using Copilot to generate test cases")
-			resp, err = getCopilotClient().Chat(prompt)
-			if err != nil {
-				return "", fmt.Errorf("Failed to get response from Copilot: %s", err.Error())
-			}
-		} else {
-			log.Infof("Using Twinkle to generate test cases")
-			resp, err = twinkle.CallTwinkleAPI(prompt)
-			if err != nil {
-				return "", fmt.Errorf("Failed to get response from Twinkle: %s", err.Error())
-			}
-		}
+	log.Infof("Prompt for method %s: %s", method.Name.Name, prompt)
 
-		// Trim the code and add to test code
-		log.Infof("Response from AI: %s", resp)
-		code, err := extractCode(resp)
-		if err != nil {
-			return "", fmt.Errorf("Failed to extract code: %s", err.Error())
-		}
-		testCode += code + "\n\n"
+	code, err := chatForCode(ctx, prompt)
+	if err != nil {
+		return generatedTest{}, err
 	}
-	return testCode, nil
+	return generatedTest{Prompt: prompt, Code: renameTestFunc(code, testFuncName)}, nil
+}
+
+// chatForCode sends the prompt to the provider and extracts the code from the response
+func chatForCode(ctx context.Context, prompt string) (string, error) {
+	p, err := getProvider()
+	if err != nil {
+		return "", fmt.Errorf("Failed to initialize provider: %s", err.Error())
+	}
+
+	log.Infof("Using %s to generate test cases", p.Name())
+	resp, err := p.Chat(ctx, prompt)
+	if liveFlag {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to get response from %s: %s", p.Name(), err.Error())
+	}
+
+	// Trim the code
+	log.Infof("Response from AI: %s", resp)
+	code, err := extractCode(resp)
+	if err != nil {
+		return "", fmt.Errorf("Failed to extract code: %s", err.Error())
+	}
+	return code, nil
 }
 
 // extractCode extracts code from the response between triple backticks.
@@ -451,7 +659,11 @@ func sortByImportNameAndName(pairs []typePair) {
 			return false // Non-empty importName should come later
 		}
 		// If both have the same importName (either both empty or both non-empty), sort by Name
-		return pairs[i].TypeName < pairs[j].TypeName
+		if pairs[i].TypeName != pairs[j].TypeName {
+			return pairs[i].TypeName < pairs[j].TypeName
+		}
+		// Break ties by importName so the prompt is identical between runs
+		return pairs[i].PackageName < pairs[j].PackageName
 	})
 }
 
@@ -466,8 +678,14 @@ func parseTypeDefination(expr ast.Expr) ([]typePair, error) {
 		}, nil
 	case *ast.StarExpr:
 		return parseTypeDefination(t.X)
+	case *ast.ParenExpr:
+		return parseTypeDefination(t.X)
 	case *ast.ArrayType:
 		return parseTypeDefination(t.Elt)
+	case *ast.Ellipsis:
+		return parseTypeDefination(t.Elt)
+	case *ast.ChanType:
+		return parseTypeDefination(t.Value)
 	case *ast.MapType:
 		keyPairs, err := parseTypeDefination(t.Key)
 		if err != nil {
@@ -488,15 +706,116 @@ func parseTypeDefination(expr ast.Expr) ([]typePair, error) {
 				},
 			}, nil
 		}
-		return []typePair{}, fmt.Errorf("unsupported selector expression: %T", t.X)
-	case *ast.InterfaceType:
+		// Only a package can qualify a type name, e.g. a.b.C is a field selection
+		log.Debugf("Ignoring selector expression %s, it is not a qualified type name", types.ExprString(t))
 		return []typePair{}, nil
+	case *ast.IndexExpr:
+		// Generic instantiation, e.g. Set[T] or List[model.User], the generic type first
+		return parseTypeExprs(t.X, t.Index)
+	case *ast.IndexListExpr:
+		return parseTypeExprs(append([]ast.Expr{t.X}, t.Indices...)...)
+	case *ast.FuncType:
+		return parseFieldListTypes(t.TypeParams, t.Params, t.Results)
+	case *ast.StructType:
+		return parseFieldListTypes(t.Fields)
+	case *ast.InterfaceType:
+		// The methods, the embedded interfaces and the type sets of the constraints
+		return parseFieldListTypes(t.Methods)
+	case *ast.UnaryExpr:
+		// ~T in a constraint
+		return parseTypeDefination(t.X)
+	case *ast.BinaryExpr:
+		// Union of a constraint, e.g. ~int | ~string
+		return parseTypeExprs(t.X, t.Y)
 	default:
 		log.Warnf("Unsupported type: %T", t)
 		return []typePair{}, nil
 	}
 }
 
+// parseTypeExprs returns the types referenced by the type expressions, in order
+func parseTypeExprs(exprs ...ast.Expr) ([]typePair, error) {
+	var pairs []typePair
+	for _, expr := range exprs {
+		exprPairs, err := parseTypeDefination(expr)
+		if err != nil {
+			return []typePair{}, err
+		}
+		pairs = append(pairs, exprPairs...)
+	}
+	return pairs, nil
+}
+
+// parseFieldListTypes returns the types of the fields, parameters, results or interface methods, in order
+func parseFieldListTypes(lists ...*ast.FieldList) ([]typePair, error) {
+	var exprs []ast.Expr
+	for _, list := range lists {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return parseTypeExprs(exprs...)
+}
+
+// typeParamNames returns the names of the type parameters of the function and of its generic receiver,
+// e.g. K and V for func (m *Map[K, V]) Get(key K) V, they don't refer to declared types
+func typeParamNames(method *ast.FuncDecl) map[string]bool {
+	names := make(map[string]bool)
+	if method.Type.TypeParams != nil {
+		for _, field := range method.Type.TypeParams.List {
+			for _, name := range field.Names {
+				names[name.Name] = true
+			}
+		}
+	}
+	for _, param := range receiverTypeParams(method) {
+		if id, ok := param.(*ast.Ident); ok {
+			names[id.Name] = true
+		}
+	}
+	return names
+}
+
+// receiverTypeParams returns the type parameters of the generic receiver of the method, e.g. K and V
+// for func (m *Map[K, V]) Get(key K) V, whatever the pointer and the parentheses around the receiver type
+func receiverTypeParams(method *ast.FuncDecl) []ast.Expr {
+	if method.Recv == nil || len(method.Recv.List) == 0 {
+		return nil
+	}
+	recv := method.Recv.List[0].Type
+	for {
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		} else if paren, ok := recv.(*ast.ParenExpr); ok {
+			recv = paren.X
+		} else {
+			break
+		}
+	}
+	switch x := recv.(type) {
+	case *ast.IndexExpr:
+		return []ast.Expr{x.Index}
+	case *ast.IndexListExpr:
+		return x.Indices
+	}
+	return nil
+}
+
+// withoutTypeParams filters out the pairs naming a type parameter
+func withoutTypeParams(pairs []typePair, typeParams map[string]bool) []typePair {
+	var filtered []typePair
+	for _, pair := range pairs {
+		if pair.PackageName == "" && typeParams[pair.TypeName] {
+			continue
+		}
+		filtered = append(filtered, pair)
+	}
+	return filtered
+}
+
 func collectMethods(node *ast.File) ([]*ast.FuncDecl, error) {
 	var methods []*ast.FuncDecl
 
@@ -523,28 +842,25 @@ func collectMethods(node *ast.File) ([]*ast.FuncDecl, error) {
 	return methods, nil
 }
 
-func generatePrompt(fset *token.FileSet, method *ast.FuncDecl, filePath string) (string, error) {
-	// generate imports
-	importSectionCode, err := generateImportSectionCode(filePath)
+func generatePrompt(fset *token.FileSet, method *ast.FuncDecl, filePath, testFuncName, coverageHint string, existingTests []string) (string, error) {
+	data, err := newPromptData(fset, method, filePath, testFuncName, coverageHint, existingTests)
 	if err != nil {
-		log.Errorf("Failed to generate import section code: %v", err)
 		return "", err
 	}
 
-	var methodCode string
-	var methodBuf bytes.Buffer
-	if err := format.Node(&methodBuf, fset, method); err != nil {
-		return "", fmt.Errorf("failed to generate source code for %s due to %s", method.Name.Name, err.Error())
+	data.Mocks, err = mocksFor(fset, method, filePath)
+	if err != nil {
+		// The context resolution reports the same load error
+		log.Debugf("[%s] No mocks generated: %v", method.Name.Name, err)
 	}
-	methodCode += methodBuf.String() + "\n"
 
-	generatedTypeDefinationCode, err := generateTypeDefinitionSectionCode(method, filePath)
+	contextItems, err := generateTypeDefinitionSectionCode(fset, method, filePath)
 	if err != nil {
 		log.Errorf("Failed to generate type definition section code: %v", err)
 		return "", err
 	}
 
-	customPrompt, err := loadPrompt("")
+	customPrompt, err := loadConfiguredPrompt()
 	if err != nil {
 		if err.Error() == "no default prompt configured" {
 			return "", fmt.Errorf("no prompt configured - please set a default prompt using: smart-testify config prompt set-default <name>")
@@ -552,53 +868,65 @@ func generatePrompt(fset *token.FileSet, method *ast.FuncDecl, filePath string)
 		return "", err
 	}
 
-	testFuncName, err := generateTestFuncName(method)
+	// Cut the least relevant context until the prompt fits in the model limits. The prompt is measured without
+	// the context, which appears in the wrapper and wherever the custom prompt uses .TypeDefs.
+	data.TypeDefs = contextPlaceholder
+	basePrompt, err := renderPrompt(customPrompt, *data)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate test function name: %s", err.Error())
+		return "", err
 	}
-
-	// Generate the final prompt with context
-	return fmt.Sprintf(`Generate unit tests for below function: 
-%s
-%s
-
-The related types and functions definition code is:
-%s
-
-You should only output the test function, nothing else. Don't output the package declaration, imports, or any other code.
-The test function name should be %s.
-
-%s
-`,
-		importSectionCode,
-		methodCode,
-		generatedTypeDefinationCode, testFuncName, customPrompt,
-	), nil
+	copies := strings.Count(basePrompt, contextPlaceholder)
+	data.TypeDefs, err = fitContext(testFuncName, contextItems, strings.ReplaceAll(basePrompt, contextPlaceholder, ""), copies)
+	if err != nil {
+		return "", err
+	}
+	return renderPrompt(customPrompt, *data)
 }
 
-func generateTypeDefinitionSectionCode(method *ast.FuncDecl, filePath string) (string, error) {
-	var allTypePairs []typePair
+// generateTypeDefinitionSectionCode collects the definitions related to the method, ranked by relevance:
+// the receiver, parameter and result types first, then the called functions, then the types used in the body,
+// then the types their definitions refer to, up to --context-depth.
+// They are resolved from the type-checked package, or looked up by name when the package can't be loaded.
+func generateTypeDefinitionSectionCode(fset *token.FileSet, method *ast.FuncDecl, filePath string) ([]budget.Item, error) {
+	items, err := resolveDefinitions(fset, method, filePath)
+	if err == nil {
+		return items, nil
+	}
+	log.Warnf("[%s] Failed to resolve the context from the type-checked package, looking it up by name: %v", method.Name.Name, err)
+
+	var signatureTypePairs []typePair
 
 	// Collect types from receiver, parameters, and results
 	if method.Recv != nil {
 		// Gather source code for the receiver type
 		pairs, err := parseTypeDefination(method.Recv.List[0].Type)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if len(pairs) == 0 {
-			return "", fmt.Errorf("receiver type not found")
+			return nil, fmt.Errorf("receiver type not found")
+		}
+		signatureTypePairs = append(signatureTypePairs, pairs...)
+	}
+
+	// The constraints of the type parameters, e.g. Number in func Sum[T Number](values []T) T
+	if method.Type.TypeParams != nil {
+		for _, typeParam := range method.Type.TypeParams.List {
+			pairs, err := parseTypeDefination(typeParam.Type)
+			if err != nil {
+				return nil, err
+			}
+			signatureTypePairs = append(signatureTypePairs, pairs...)
 		}
-		allTypePairs = append(allTypePairs, pairs...)
 	}
 
 	if method.Type.Params != nil {
 		for _, param := range method.Type.Params.List {
 			pairs, err := parseTypeDefination(param.Type)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			allTypePairs = append(allTypePairs, pairs...)
+			signatureTypePairs = append(signatureTypePairs, pairs...)
 		}
 	}
 
@@ -606,41 +934,62 @@ func generateTypeDefinitionSectionCode(method *ast.FuncDecl, filePath string) (s
 		for _, result := range method.Type.Results.List {
 			pairs, err := parseTypeDefination(result.Type)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			allTypePairs = append(allTypePairs, pairs...)
+			signatureTypePairs = append(signatureTypePairs, pairs...)
 		}
 	}
 
 	// Gather types and functions used in the method body
 	usedFunctions, usedTypes, err := collectTypesAndFunctionsFromBody(method.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Append these used types and functions to the type list
-	allTypePairs = append(allTypePairs, usedTypes...)
+	// The type parameters are not declared types
+	typeParams := typeParamNames(method)
+	signatureTypePairs = withoutTypeParams(signatureTypePairs, typeParams)
+	usedTypes = withoutTypeParams(usedTypes, typeParams)
+
+	// Types already in the signature keep their higher priority
+	signatureTypes := make(map[string]bool)
+	for _, pair := range signatureTypePairs {
+		signatureTypes[pair.PackageName+"."+pair.TypeName] = true
+	}
+	var bodyTypePairs []typePair
+	for _, pair := range usedTypes {
+		if !signatureTypes[pair.PackageName+"."+pair.TypeName] {
+			bodyTypePairs = append(bodyTypePairs, pair)
+		}
+	}
 
 	// Generate type-related code
-	generatedTypeDefinationCode, err := generateTypeDefinition(filePath, allTypePairs)
+	items, err = generateTypeDefinition(filePath, signatureTypePairs, prioritySignatureType)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Optionally, add function definitions found in the method body (e.g., via FindFunctionSource)
+	// Add function definitions found in the method body (e.g., via FindFunctionSource)
 	sortFunctionPairs(usedFunctions)
 	for _, funcDef := range usedFunctions {
 		funcSource, err := util.FindFunctionSource(filePath, funcDef.PackageName, funcDef.TypeName, funcDef.FuncName)
 		if err != nil {
-			return "", nil
+			log.Warnf("Failed to find source of function %s: %v", funcDef.FuncName, err)
+			continue
 		}
 		if len(strings.TrimSpace(funcSource)) > 0 {
-			funcSource = fmt.Sprintf("Package: %s \nMethod: %s\n", funcDef.PackageName, funcDef.FuncName) + funcSource
-			generatedTypeDefinationCode += "\n\n" + funcSource
+			items = append(items, funcItem(funcDef.PackageName, funcDef.FuncName, funcSource))
 		}
 	}
 
-	return generatedTypeDefinationCode, nil
+	bodyItems, err := generateTypeDefinition(filePath, bodyTypePairs, priorityBodyType)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, bodyItems...)
+	items = append(items, generateNestedTypeDefinition(filePath, append(signatureTypePairs, bodyTypePairs...))...)
+
+	return items, nil
 }
 
 func sortFunctionPairs(functions []functionPair) {
@@ -731,71 +1080,145 @@ func collectTypesAndFunctionsFromBody(body *ast.BlockStmt) ([]functionPair, []ty
 	return uniqueFunctionPair(usedFunctions), uniqueTypePair(usedTypes), nil
 }
 
-func generateImportSectionCode(path string) (string, error) {
-	// Open the Go file
-	file, err := os.Open(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
+func generateTypeDefinition(filePath string, pairs []typePair, priority int) ([]budget.Item, error) {
+	if len(pairs) == 0 {
+		return nil, nil
 	}
-	defer file.Close()
+	uniquePairs := uniqueTypePair(pairs)
+	sortByImportNameAndName(uniquePairs)
 
-	// Parse the Go file
-	fs := token.NewFileSet()
-	node, err := parser.ParseFile(fs, path, file, parser.ImportsOnly)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse file: %w", err)
-	}
+	var items []budget.Item
 
-	// Collect the imports
-	var imports []string
-	for _, imp := range node.Imports {
-		// Clean the import string and store it
-		imports = append(imports, imp.Path.Value)
+	for _, pair := range uniquePairs {
+		sourceCode, err := util.FindTypeSource(filePath, pair.PackageName, pair.TypeName)
+		if err != nil {
+			return nil, err
+		}
+		if sourceCode != "" {
+			items = append(items, typeItem(pair.PackageName, pair.TypeName, sourceCode, priority))
+		}
 	}
 
-	// Format the imports into a Go code string
-	var importCode strings.Builder
-	importCode.WriteString("import (\n")
-	for _, imp := range imports {
-		importCode.WriteString(fmt.Sprintf("\t%v\n", imp))
+	return items, nil
+}
+
+// generateNestedTypeDefinition follows the types referenced by the definitions of the types, up to --context-depth.
+// Only the types of the same package are followed from the types of another package, as its imports are unknown here.
+func generateNestedTypeDefinition(filePath string, pairs []typePair) []budget.Item {
+	seen := make(map[typePair]bool)
+	for _, pair := range pairs {
+		seen[pair] = true
 	}
-	importCode.WriteString(")\n")
 
-	return importCode.String(), nil
+	var items []budget.Item
+	parents := uniqueTypePair(pairs)
+	sortByImportNameAndName(parents)
+	for depth := 1; depth <= contextDepthFlag && len(parents) > 0; depth++ {
+		var next []typePair
+		for _, parent := range parents {
+			sourceCode, err := util.FindTypeSource(filePath, parent.PackageName, parent.TypeName)
+			if err != nil || sourceCode == "" {
+				continue
+			}
+			for _, child := range referencedTypePairs(parent, sourceCode) {
+				if seen[child] {
+					continue
+				}
+				seen[child] = true
+
+				childSource, err := util.FindTypeSource(filePath, child.PackageName, child.TypeName)
+				if err != nil {
+					log.Debugf("Failed to find source of type %s: %v", qualifiedName(child.PackageName, child.TypeName), err)
+					continue
+				}
+				if childSource == "" {
+					continue
+				}
+				items = append(items, nestedTypeItem(child.PackageName, child.TypeName, childSource, qualifiedName(parent.PackageName, parent.TypeName), depth))
+				next = append(next, child)
+			}
+		}
+		parents = next
+	}
+	return items
 }
 
-func generateTypeDefinition(filePath string, pairs []typePair) (string, error) {
-	if len(pairs) == 0 {
-		return "", nil
+// referencedTypePairs returns the types the definition of the parent type refers to, relative to the source file
+func referencedTypePairs(parent typePair, sourceCode string) []typePair {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+sourceCode, 0)
+	if err != nil {
+		return nil
 	}
-	uniquePairs := uniqueTypePair(pairs)
-	sortByImportNameAndName(uniquePairs)
 
-	var resultCode strings.Builder
+	var exprs []ast.Expr
+	typeParams := make(map[string]bool)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			if typeSpec.TypeParams != nil {
+				for _, typeParam := range typeSpec.TypeParams.List {
+					exprs = append(exprs, typeParam.Type)
+					for _, name := range typeParam.Names {
+						typeParams[name.Name] = true
+					}
+				}
+			}
+			exprs = append(exprs, typeSpec.Type)
+		}
+	}
 
-	for _, pair := range uniquePairs {
-		sourceCode, err := util.FindTypeSource(filePath, pair.PackageName, pair.TypeName)
+	var pairs []typePair
+	for _, expr := range exprs {
+		children, err := parseTypeDefination(expr)
 		if err != nil {
-			return "", err
+			continue
 		}
-		if sourceCode != "" {
-			if pair.PackageName == "" {
-				resultCode.WriteString(fmt.Sprintf("Model: %s\nDefinition:\n%s\n", pair.TypeName, sourceCode))
-			} else {
-				resultCode.WriteString(fmt.Sprintf("Package: %s \nModel: %s\nDefinition:\n%s\n", pair.PackageName, pair.TypeName, sourceCode))
+		for _, child := range withoutTypeParams(children, typeParams) {
+			if child.PackageName == "" && types.Universe.Lookup(child.TypeName) != nil {
+				continue // Predeclared types, such as string
+			}
+			if parent.PackageName != "" {
+				if child.PackageName != "" {
+					continue
+				}
+				child.PackageName = parent.PackageName
 			}
+			pairs = append(pairs, child)
 		}
 	}
-
-	return resultCode.String(), nil
+	return pairs
 }
 
 func init() {
-	generateCmd.Flags().StringVarP(&modeFlag, "mode", "m", modeAppend, "Mode controls whether the test cases will be generated when the test function/file(depends on the --granularity flag) already exists. Possible values: skip, append.")
+	generateCmd.Flags().StringVarP(&modeFlag, "mode", "m", modeAppend, "Mode controls whether the test cases will be generated when the test function/file(depends on the --granularity flag) already exists. Possible values: skip, append, replace. "+
+		"append merges the generated cases into the existing test function as subtests, replace replaces the existing test function.")
 	generateCmd.Flags().StringVarP(&filter, "filter", "f", "", "Regex filter for functions/filter to generate tests for")
 	generateCmd.Flags().StringVarP(&granularity, "granularity", "g", granularityFunction, "Used with the append mode: file or function. "+
 		"When mode=skip and granularity=file, the entire test file is skipped. "+
 		"When mode=skip and granularity=function, the test function is skipped. "+
-		"When mode=append, no matter the granularity, the test function is appended to the test file.")
+		"When mode=append or mode=replace, no matter the granularity, the test function is merged into the test file.")
+	generateCmd.Flags().IntVarP(&jobsFlag, "jobs", "j", 1, "Number of functions and files processed in parallel")
+	generateCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Print the generated test files instead of writing them, the logs are sent to stderr")
+	generateCmd.Flags().BoolVar(&diffFlag, "diff", false, "Print a unified diff of each test file instead of writing it, the logs are sent to stderr")
+	generateCmd.Flags().StringVarP(&outputDirFlag, "output-dir", "o", "", "Write the generated test files to this directory, mirroring their path relative to the current directory, instead of in place")
+	generateCmd.Flags().Float64Var(&coverageTargetFlag, "coverage-target", 0, "Coverage percentage to reach, e.g. 80. Only the functions below the target are generated for, focusing on their uncovered lines, and the coverage is measured again after each round")
+	generateCmd.Flags().IntVar(&coverageRoundsFlag, "coverage-rounds", defaultCoverageRounds, "Maximum number of rounds of generation and coverage measurement when --coverage-target is set")
+	generateCmd.Flags().BoolVar(&verifyFlag, "verify", false, "Run go vet and go test on each generated test function, sending the failures back to the model for a fix")
+	generateCmd.Flags().IntVar(&maxRepairAttemptsFlag, "max-repair-attempts", defaultMaxRepairAttempts, "Maximum number of times a failing test function is sent back to the model for a fix")
+	generateCmd.Flags().StringVar(&onFailureFlag, "on-failure", onFailureQuarantine, "What to do with a test function still failing after the repair attempts: drop, or quarantine it in a <file>_quarantine_test.go file excluded from the builds by the "+quarantineBuildTag+" build tag")
+	generateCmd.Flags().IntVar(&maxAttemptsFlag, "max-attempts", 0, "Maximum number of attempts per model call when it fails with a 429, 5xx, timeout or network error. Defaults to the config or 3")
+	generateCmd.Flags().DurationVar(&requestTimeoutFlag, "request-timeout", 0, "Timeout of each model call, e.g. 90s. Defaults to the config or 5m")
+	generateCmd.Flags().BoolVar(&mocksFlag, "mocks", false, "Generate testify mocks of the interfaces the functions depend on into the "+mocksFileName+" file of their package")
+	generateCmd.Flags().IntVar(&contextDepthFlag, "context-depth", defaultContextDepth, "How many levels of type definitions are followed through struct fields, embedded types and named slices and maps, 0 only includes the types referenced by the function")
+	generateCmd.Flags().IntVar(&maxPromptTokensFlag, "max-prompt-tokens", 0, "Token budget of each prompt, the least relevant context is shortened or dropped to fit. Defaults to the limit of the model")
+	generateCmd.Flags().BoolVar(&liveFlag, "live", false, "Print the response of the model to stderr while it is streamed")
+	generateCmd.Flags().StringVar(&recordFlag, "record", "", "Record every prompt and response to the given cassette file, keyed by prompt hash")
+	generateCmd.Flags().Lookup("record").NoOptDefVal = defaultCassettePath
+	generateCmd.Flags().StringVar(&replayFlag, "replay", "", "Serve responses from the given cassette file instead of calling the model, fails on prompts that were not recorded")
+	generateCmd.Flags().Lookup("replay").NoOptDefVal = defaultCassettePath
 	generateCmd.Flags().BoolVarP(&ignoreErrorFlag, "ignore-error", "c", false, "When Smart-Testify is processing multiple fils, it will stop processing when it encounters an error. However, you can use --ignore-error to ignore the error and continue processing the next file.")
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExtractCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "go fence",
+			response: "Here is the test:\n```go\nfunc TestX(t *testing.T) {}\n```\nDone.",
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "plain fence",
+			response: "```\nfunc TestX(t *testing.T) {}\n```",
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "blank lines around the code",
+			response: "```go\n\n\nfunc TestX(t *testing.T) {}\n\n```",
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "backticks inside the code",
+			response: "```go\nfunc TestX(t *testing.T) {\n\ts := `raw`\n}\n```",
+			want:     "func TestX(t *testing.T) {\n\ts := `raw`\n}",
+		},
+		{
+			name:     "several blocks are joined up to the last fence",
+			response: "```go\nfunc TestA(t *testing.T) {}\n```\nand\n```go\nfunc TestB(t *testing.T) {}\n```",
+			want:     "func TestA(t *testing.T) {}\n```\nand\n```go\nfunc TestB(t *testing.T) {}",
+		},
+		{
+			name:     "no fence",
+			response: "func TestX(t *testing.T) {}",
+			wantErr:  "missing starting backticks",
+		},
+		{
+			name:     "unterminated go fence",
+			response: "```go\nfunc TestX(t *testing.T) {}",
+			wantErr:  "missing ending backticks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractCode(tt.response)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractCode() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractCode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("extractCode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeParamNames(t *testing.T) {
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{method: "func (s *Service) Get() {}", want: nil},
+		{method: "func (s *Set[T]) Add(v T) {}", want: []string{"T"}},
+		{method: "func (s *(Set[T])) Add(v T) {}", want: []string{"T"}},
+		{method: "func (m (*Map[K, V])) Get(key K) V { var v V; return v }", want: []string{"K", "V"}},
+		{method: "func Keys[K comparable, V any](m map[K]V) []K { return nil }", want: []string{"K", "V"}},
+	}
+
+	for _, tt := range tests {
+		names := typeParamNames(parseFuncDecl(t, tt.method))
+		var got []string
+		for name := range names {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("typeParamNames(%s) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
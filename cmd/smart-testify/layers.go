@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// repoConfigNames are the names of the repository config, looked up from the target path to the root
+var repoConfigNames = []string{".smart-testify.yaml", ".smart-testify.yml", ".smart-testify.json"}
+
+// configEnvVars maps the environment variables to the config keys they set
+var configEnvVars = map[string]string{
+	"SMART_TESTIFY_MODEL":              "model",
+	"SMART_TESTIFY_PROMPT":             "prompt",
+	"SMART_TESTIFY_PROMPT_FILE":        "prompt_file",
+	"SMART_TESTIFY_TEST_NAME_TEMPLATE": "test_name_template",
+	"SMART_TESTIFY_TEST_FILE_TEMPLATE": "test_file_template",
+	"SMART_TESTIFY_INCLUDE":            "include",
+	"SMART_TESTIFY_EXCLUDE":            "exclude",
+	"SMART_TESTIFY_OPENAI_API_KEY":     "openai.api_key",
+	"SMART_TESTIFY_TWINKLE_AUTH_TOKEN": "twinkle.auth_token",
+}
+
+// generateEnvPrefix prefixes the environment variables setting generate flags, e.g. SMART_TESTIFY_GENERATE_JOBS
+const generateEnvPrefix = "SMART_TESTIFY_GENERATE_"
+
+// repoConfigKeys are the keys the repository config may set, the endpoints, credentials and headers
+// of the providers are only read from the global config and the environment
+var repoConfigKeys = map[string]bool{
+	"model":              true,
+	"prompt":             true,
+	"prompt_file":        true,
+	"test_name_template": true,
+	"test_file_template": true,
+	"include":            true,
+	"exclude":            true,
+	"generate":           true,
+}
+
+// pathKeys hold paths, which are relative to the file of the layer that sets them
+var pathKeys = []string{"prompt_file", "test_file_template"}
+
+// repoPathFlags are the generate flags holding paths, the repository config sets them relative to its directory
+var repoPathFlags = []string{"output-dir", "record", "replay"}
+
+// configSearchDir is where the lookup of the repository config starts, the current directory if empty
+var configSearchDir string
+
+// configLayer holds the values set by one source of configuration
+type configLayer struct {
+	Source string
+	Values map[string]interface{}
+}
+
+// loadConfigLayers returns the layers from the lowest to the highest precedence:
+// defaults, global config, repository config and environment variables
+func loadConfigLayers() ([]configLayer, string, error) {
+	layers := []configLayer{{
+		Source: "default",
+		Values: map[string]interface{}{"model": modelTwinkle},
+	}}
+
+	// Create the global config if it doesn't exist yet
+	if _, err := loadConfig(); err != nil {
+		return nil, "", err
+	}
+	global, err := readConfigFile(getConfigPath())
+	if err != nil {
+		return nil, "", err
+	}
+	layers = append(layers, configLayer{Source: "global " + getConfigPath(), Values: global})
+
+	dir := configSearchDir
+	if dir == "" {
+		dir = "."
+	}
+	repoPath := findRepoConfig(dir)
+	var repoRoot string
+	if repoPath != "" {
+		repo, err := readConfigFile(repoPath)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := restrictRepoConfig(repo, repoPath); err != nil {
+			return nil, "", err
+		}
+		layers = append(layers, configLayer{Source: "repo " + repoPath, Values: repo})
+		repoRoot = filepath.Dir(repoPath)
+	}
+
+	env := make(map[string]interface{})
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if key, ok := configEnvVars[name]; ok {
+			if key == "include" || key == "exclude" {
+				setKey(env, key, splitList(value))
+			} else {
+				setKey(env, key, value)
+			}
+			continue
+		}
+		if strings.HasPrefix(name, generateEnvPrefix) {
+			flagName := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, generateEnvPrefix), "_", "-"))
+			setKey(env, "generate."+flagName, value)
+		}
+	}
+	if wd, err := os.Getwd(); err == nil {
+		resolvePaths(env, wd)
+	}
+	layers = append(layers, configLayer{Source: "env", Values: env})
+	return layers, repoRoot, nil
+}
+
+// readConfigFile reads a JSON or YAML config into a map, the relative paths are resolved from its directory
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	values := make(map[string]interface{})
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	resolvePaths(values, filepath.Dir(path))
+	return values, nil
+}
+
+func resolvePaths(values map[string]interface{}, dir string) {
+	for _, key := range pathKeys {
+		if path, ok := values[key].(string); ok && path != "" && !filepath.IsAbs(path) {
+			values[key] = filepath.Join(dir, path)
+		}
+	}
+}
+
+// restrictRepoConfig drops the keys the repository config may not set with a warning, and checks
+// that its paths stay inside the repository
+func restrictRepoConfig(values map[string]interface{}, repoPath string) error {
+	ignored := make(map[string]interface{})
+	for key, value := range values {
+		if !repoConfigKeys[key] {
+			ignored[key] = value
+			delete(values, key)
+		}
+	}
+	if len(ignored) > 0 {
+		keys := flattenConfig(ignored, "", make(map[string]interface{}))
+		log.Warnf("Ignoring %s set by the repository config %s, only the global config and the environment can set them",
+			strings.Join(keys, ", "), repoPath)
+	}
+
+	root := filepath.Dir(repoPath)
+	for _, key := range pathKeys {
+		path, ok := values[key].(string)
+		if !ok || path == "" {
+			continue
+		}
+		if !insideDir(root, path) {
+			return fmt.Errorf("%s %s set by the repository config %s is outside the repository", key, path, repoPath)
+		}
+	}
+
+	generate, _ := values["generate"].(map[string]interface{})
+	for _, name := range repoPathFlags {
+		value, ok := generate[name]
+		if !ok || value == nil || value == "" {
+			continue
+		}
+		path := fmt.Sprint(value)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+			generate[name] = path
+		}
+		if !insideDir(root, path) {
+			return fmt.Errorf("generate.%s %s set by the repository config %s is outside the repository", name, path, repoPath)
+		}
+	}
+	return nil
+}
+
+// insideDir reports whether the path is in the directory once the symbolic links are resolved
+func insideDir(dir, path string) bool {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		if resolvedDir, err := filepath.EvalSymlinks(dir); err == nil {
+			dir, path = resolvedDir, resolved
+		}
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// findRepoConfig returns the closest repository config from dir to the root, or an empty string
+func findRepoConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	for {
+		for _, name := range repoConfigNames {
+			if path := filepath.Join(dir, name); fileExists(path) {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// mergeConfigLayers merges the layers key by key, and returns where each value comes from
+func mergeConfigLayers(layers []configLayer) (map[string]interface{}, map[string]string) {
+	merged := make(map[string]interface{})
+	sources := make(map[string]string)
+	for _, layer := range layers {
+		mergeValues(merged, layer.Values, "", layer.Source, sources)
+	}
+	return merged, sources
+}
+
+func mergeValues(dst, src map[string]interface{}, prefix, source string, sources map[string]string) {
+	for key, value := range src {
+		path := prefix + key
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			dstMap, ok := dst[key].(map[string]interface{})
+			if !ok {
+				dstMap = make(map[string]interface{})
+				dst[key] = dstMap
+			}
+			mergeValues(dstMap, srcMap, path+".", source, sources)
+			continue
+		}
+		dst[key] = value
+		sources[path] = source
+	}
+}
+
+// setKey sets the value at the dotted key, creating the intermediate maps
+func setKey(values map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := values[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			values[part] = next
+		}
+		values = next
+	}
+	values[parts[len(parts)-1]] = value
+}
+
+// flattenConfig returns the leaves of the config keyed by their dotted path, in order
+func flattenConfig(values map[string]interface{}, prefix string, leaves map[string]interface{}) []string {
+	var keys []string
+	for key, value := range values {
+		path := prefix + key
+		if nested, ok := value.(map[string]interface{}); ok {
+			keys = append(keys, flattenConfig(nested, path+".", leaves)...)
+			continue
+		}
+		leaves[path] = value
+		keys = append(keys, path)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitList(value string) []interface{} {
+	var list []interface{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// matchGlob matches the slash separated path against a glob, where ** matches any number of directories
+func matchGlob(pattern, path string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	matched, err := regexp.MatchString(re.String(), path)
+	return err == nil && matched
+}
+
+// applyGenerateConfig sets the generate flags which are not given on the command line from the config
+func applyGenerateConfig(cmd *cobra.Command) error {
+	config := getEffectiveConfig()
+
+	names := make([]string, 0, len(config.Generate))
+	for name := range config.Generate {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := "generate." + name
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown generate flag %q set by %s", name, configSources[key])
+		}
+		if flag.Changed {
+			configSources[key] = "flag --" + name
+			continue
+		}
+		if err := cmd.Flags().Set(name, fmt.Sprint(config.Generate[name])); err != nil {
+			return fmt.Errorf("invalid value of generate flag %q set by %s: %v", name, configSources[key], err)
+		}
+		log.Debugf("Flag --%s set to %v by %s", name, config.Generate[name], configSources[key])
+	}
+	return nil
+}
+
+// includedByConfig reports whether the file matches the include globs of the config and none of its exclude globs
+func includedByConfig(filePath string) bool {
+	config := getEffectiveConfig()
+	if len(config.Include) == 0 && len(config.Exclude) == 0 {
+		return true
+	}
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(config.Root, absPath)
+	if err != nil {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+
+	if len(config.Include) > 0 {
+		included := false
+		for _, pattern := range config.Include {
+			if matchGlob(pattern, rel) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, pattern := range config.Exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRestrictRepoConfig(t *testing.T) {
+	root := t.TempDir()
+	repoPath := filepath.Join(root, ".smart-testify.yaml")
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "allowed keys are kept",
+			values: map[string]interface{}{
+				"model":       "openai",
+				"prompt_file": filepath.Join(root, "prompt.txt"),
+				"include":     []interface{}{"internal/**"},
+				"generate":    map[string]interface{}{"jobs": 4},
+			},
+			want: map[string]interface{}{
+				"model":       "openai",
+				"prompt_file": filepath.Join(root, "prompt.txt"),
+				"include":     []interface{}{"internal/**"},
+				"generate":    map[string]interface{}{"jobs": 4},
+			},
+		},
+		{
+			name: "provider settings are ignored",
+			values: map[string]interface{}{
+				"model":   "twinkle",
+				"openai":  map[string]interface{}{"base_url": "https://example.com", "api_key": "key"},
+				"twinkle": map[string]interface{}{"endpoint": "https://example.com", "auth_header": "X-Token"},
+				"custom":  map[string]interface{}{"url": "https://example.com", "headers": map[string]interface{}{"Authorization": "{{.Token}}"}},
+				"local":   map[string]interface{}{"endpoint": "http://example.com"},
+				"retry":   map[string]interface{}{"max_attempts": 10},
+			},
+			want: map[string]interface{}{"model": "twinkle"},
+		},
+		{
+			name:    "prompt file outside the repository",
+			values:  map[string]interface{}{"prompt_file": filepath.Join(root, "..", "prompt.txt")},
+			wantErr: "prompt_file",
+		},
+		{
+			name:    "test file template through a symbolic link out of the repository",
+			values:  map[string]interface{}{"test_file_template": filepath.Join(root, "link")},
+			wantErr: "test_file_template",
+		},
+		{
+			name: "generate paths are resolved from the repository",
+			values: map[string]interface{}{
+				"generate": map[string]interface{}{"output-dir": "gen", "record": filepath.Join(root, "testdata", "cassette.json"), "replay": "", "jobs": 2},
+			},
+			want: map[string]interface{}{
+				"generate": map[string]interface{}{"output-dir": filepath.Join(root, "gen"), "record": filepath.Join(root, "testdata", "cassette.json"), "replay": "", "jobs": 2},
+			},
+		},
+		{
+			name:    "output directory outside the repository",
+			values:  map[string]interface{}{"generate": map[string]interface{}{"output-dir": "../gen"}},
+			wantErr: "generate.output-dir",
+		},
+		{
+			name:    "absolute record path outside the repository",
+			values:  map[string]interface{}{"generate": map[string]interface{}{"record": filepath.Join(outside, "cassette.json")}},
+			wantErr: "generate.record",
+		},
+		{
+			name:    "replay through a symbolic link out of the repository",
+			values:  map[string]interface{}{"generate": map[string]interface{}{"replay": "link"}},
+			wantErr: "generate.replay",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := restrictRepoConfig(tt.values, repoPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("restrictRepoConfig() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("restrictRepoConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.values, tt.want) {
+				t.Errorf("restrictRepoConfig() = %v, want %v", tt.values, tt.want)
+			}
+		})
+	}
+}
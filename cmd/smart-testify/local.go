@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"smart-testify/internal/local"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	localBackend     string
+	localEndpoint    string
+	localModel       string
+	localContextSize int
+	localTemperature float64
+)
+
+var localCmd = &cobra.Command{
+	Use:   "local",
+	Short: "Configure settings for models served locally by Ollama or llama.cpp.",
+}
+
+var localSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set the local server and model settings, only the given flags are updated",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			log.Errorf("Failed to load config: %v", err)
+			return
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("backend") {
+			if localBackend != local.BackendOllama && localBackend != local.BackendLlamaCpp {
+				log.Errorf("Invalid backend: %s, must be one of %v", localBackend, local.Backends)
+				return
+			}
+			config.Local.Backend = localBackend
+		}
+		if flags.Changed("endpoint") {
+			config.Local.Endpoint = localEndpoint
+		}
+		if flags.Changed("model") {
+			config.Local.Model = localModel
+		}
+		if flags.Changed("context-size") {
+			config.Local.ContextSize = localContextSize
+		}
+		if flags.Changed("temperature") {
+			config.Local.Temperature = localTemperature
+		}
+
+		if err := saveConfig(config); err != nil {
+			log.Errorf("Failed to save config: %v", err)
+			return
+		}
+		fmt.Println("Config updated: local model settings saved")
+	},
+}
+
+func init() {
+	localSetCmd.Flags().StringVar(&localBackend, "backend", "", fmt.Sprintf("Local server type, one of %v", local.Backends))
+	localSetCmd.Flags().StringVar(&localEndpoint, "endpoint", "", "Address of the local server, defaults to http://localhost:11434 for ollama and http://localhost:8080 for llamacpp")
+	localSetCmd.Flags().StringVar(&localModel, "model", "", "Name of the model, e.g. llama3, only used by ollama")
+	localSetCmd.Flags().IntVar(&localContextSize, "context-size", 0, "Context size of the model in tokens, 0 means the server default")
+	localSetCmd.Flags().Float64Var(&localTemperature, "temperature", 0, "Sampling temperature")
+
+	localCmd.AddCommand(localSetCmd)
+}
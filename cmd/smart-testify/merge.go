@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"smart-testify/internal/merge"
+	"sort"
+)
+
+// mergeTestCode merges the generated test code into the code of the test file, depending on --mode
+// the functions which already exist receive the generated cases as subtests or are replaced
+func mergeTestCode(code, testCode string) (string, error) {
+	mode := merge.Append
+	if modeFlag == modeReplace {
+		mode = merge.Replace
+	}
+
+	merged, report, err := merge.Merge(code, testCode, mode)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range report.Merged {
+		log.Debugf("[%s] Generated cases merged as subtests", name)
+	}
+	for _, name := range report.Replaced {
+		log.Debugf("[%s] Replaced by the generated one", name)
+	}
+	renamed := make([]string, 0, len(report.Renamed))
+	for name := range report.Renamed {
+		renamed = append(renamed, name)
+	}
+	sort.Strings(renamed)
+	for _, name := range renamed {
+		log.Warnf("[%s] Already declared differently in the test file, the generated one is renamed to %s", name, report.Renamed[name])
+	}
+	for _, name := range report.Skipped {
+		log.Warnf("[%s] Already declared in the test file, the generated declaration is skipped", name)
+	}
+	return merged, nil
+}
+
+// appendTestCode merges the generated test code into the code of the test file, or appends it as
+// it is if it can't be parsed. The code which conflicts with itself is dropped, as it can't build.
+func appendTestCode(code, testCode string) string {
+	merged, err := mergeTestCode(code, testCode)
+	if errors.Is(err, merge.ErrConflict) {
+		log.Errorf("Failed to merge the generated code, it is dropped: %v", err)
+		return code
+	}
+	if err != nil {
+		log.Warnf("Failed to merge the generated code, appending it as is: %v", err)
+		return code + testCode + "\n\n"
+	}
+	return merged
+}
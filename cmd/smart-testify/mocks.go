@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"smart-testify/internal/loader"
+	"smart-testify/internal/merge"
+	"smart-testify/internal/mockgen"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mocksFileName is the file of each package the mocks are generated into
+const mocksFileName = "mocks_test.go"
+
+var mocksFlag bool
+
+// packageMocks holds the mocks registered for each package, keyed by directory
+var packageMocks sync.Map
+
+// mockSet is the mocks of a package, in the order they were registered
+type mockSet struct {
+	mu          sync.Mutex
+	packageName string         // Package clause of the test files, e.g. repo or repo_test
+	testPackage *types.Package // Package the mocks are declared in
+	mocks       []*mockgen.Mock
+	byInterface map[string]*mockgen.Mock // Keyed by the path and name of the interface
+	names       map[string]string        // Names declared in the package of the test files, with the interface they mock if any
+	dirty       bool                     // Whether mocks were registered since the mocks file was last emitted
+}
+
+// mocksFor generates the mocks of the interfaces the method depends on, and registers them so they
+// are added to the mocks file of the package
+func mocksFor(fset *token.FileSet, method *ast.FuncDecl, filePath string) ([]*mockgen.Mock, error) {
+	if !mocksFlag {
+		return nil, nil
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := packageLoader.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := packageLoader.FindFunc(pkg, filePath, fset.Position(method.Name.Pos()).Line, method.Name.Name)
+	if err != nil {
+		return nil, err
+	}
+	interfaces := packageLoader.Interfaces(pkg, fn)
+	if len(interfaces) == 0 {
+		return nil, nil
+	}
+
+	entry, _ := packageMocks.LoadOrStore(dir, &mockSet{})
+	set := entry.(*mockSet)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.byInterface == nil {
+		set.init(dir, filePath, pkg)
+	}
+
+	var mocks []*mockgen.Mock
+	for _, named := range interfaces {
+		key := named.Obj().Pkg().Path() + "." + named.Obj().Name()
+		if mock, ok := set.byInterface[key]; ok {
+			mocks = append(mocks, mock)
+			continue
+		}
+
+		mock, err := mockgen.Generate(named, set.mockName(named), set.testPackage)
+		if err != nil {
+			log.Warnf("[%s] No mock generated: %v", method.Name.Name, err)
+			continue
+		}
+		set.byInterface[key] = mock
+		set.names[mock.Name] = mock.Interface
+		set.mocks = append(set.mocks, mock)
+		set.dirty = true
+		mocks = append(mocks, mock)
+	}
+	return mocks, nil
+}
+
+// init reads the package clause of the test files of the package in dir and the names they can't reuse:
+// the declarations of the package, for the test files which are part of it, and of the test files
+func (s *mockSet) init(dir, filePath string, pkg *loader.Package) {
+	s.packageName = testPackageName(dir, filePath, pkg.Name)
+	s.byInterface = make(map[string]*mockgen.Mock)
+	s.names = declaredTestNames(dir, s.packageName)
+
+	if s.packageName != pkg.Name {
+		// The mocks of an external test package refer to the package under test by its name
+		s.testPackage = types.NewPackage(pkg.Path+"_test", s.packageName)
+		return
+	}
+	s.testPackage = pkg.Types
+	if pkg.Types != nil {
+		for _, name := range pkg.Types.Scope().Names() {
+			if _, ok := s.names[name]; !ok {
+				s.names[name] = ""
+			}
+		}
+	}
+}
+
+// mockName names the mock of the interface mockFoo, or mockPkgFoo if the name is taken, followed by a number
+// if both are. The mock already declared for the interface in the mocks file keeps its name.
+func (s *mockSet) mockName(named *types.Named) string {
+	iface := types.TypeString(named, func(p *types.Package) string {
+		if p.Path() == s.testPackage.Path() {
+			return ""
+		}
+		return p.Name()
+	})
+	candidates := []string{
+		"mock" + upperFirst(named.Obj().Name()),
+		"mock" + upperFirst(named.Obj().Pkg().Name()) + upperFirst(named.Obj().Name()),
+	}
+	for i := 1; ; i++ {
+		for _, name := range candidates {
+			if i > 1 {
+				name += strconv.Itoa(i)
+			}
+			if mocked, taken := s.names[name]; !taken || mocked == iface {
+				return name
+			}
+		}
+	}
+}
+
+// testPackageName returns the package clause of the mocks file, or of the test file of the source file,
+// or the name of the package if there is neither
+func testPackageName(dir, filePath, packageName string) string {
+	for _, path := range []string{filepath.Join(dir, mocksFileName), strings.TrimSuffix(filePath, ".go") + "_test.go"} {
+		if file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly); err == nil {
+			return file.Name.Name
+		}
+	}
+	return packageName
+}
+
+// declaredTestNames returns the package level names declared by the test files of the package in dir,
+// with the interface mocked by the mocks declared in them, e.g. repo.Store for mockStore
+func declaredTestNames(dir, packageName string) map[string]string {
+	names := make(map[string]string)
+	paths, _ := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	for _, path := range paths {
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ParseComments)
+		if err != nil || file.Name.Name != packageName {
+			continue
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					names[decl.Name.Name] = ""
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						doc := spec.Doc
+						if doc == nil {
+							doc = decl.Doc
+						}
+						names[spec.Name.Name] = ""
+						if mocked, ok := strings.CutPrefix(strings.TrimSpace(doc.Text()), spec.Name.Name+" is a testify mock of "); ok {
+							names[spec.Name.Name] = mocked
+						}
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							names[name.Name] = ""
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// code returns the mocks file with the registered mocks merged into it, the mocks already declared
+// in the file are kept as they are
+func (s *mockSet) code(mocksPath string) (string, error) {
+	var imports []string
+	var generated strings.Builder
+	for _, mock := range s.mocks {
+		imports = append(imports, mock.Imports...)
+		generated.WriteString(mock.Code + "\n")
+	}
+	sort.Strings(imports)
+
+	code, err := ioutil.ReadFile(mocksPath)
+	if os.IsNotExist(err) {
+		code = []byte(fmt.Sprintf("// Code generated by smart-testify.\n\npackage %s\n", s.packageName))
+	} else if err != nil {
+		return "", err
+	}
+
+	merged, _, err := merge.Merge(string(code), generated.String(), merge.Append)
+	if err != nil {
+		return "", fmt.Errorf("failed to merge the mocks into %s: %v", mocksPath, err)
+	}
+	return merge.AddImports(merged, imports)
+}
+
+// pendingMocks returns the mocks file of the package in dir if it has mocks not emitted yet, so the
+// verification can see them through the overlay
+func pendingMocks(dir string) (string, string, bool, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", false, err
+	}
+	entry, ok := packageMocks.Load(dir)
+	if !ok {
+		return "", "", false, nil
+	}
+	set := entry.(*mockSet)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if !set.dirty && writesInPlace() {
+		return "", "", false, nil
+	}
+	mocksPath := filepath.Join(dir, mocksFileName)
+	code, err := set.code(mocksPath)
+	if err != nil {
+		return "", "", false, err
+	}
+	return mocksPath, code, true, nil
+}
+
+// flushMocks emits the mocks file of the package in dir if mocks were registered since it was last emitted
+func flushMocks(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	entry, ok := packageMocks.Load(dir)
+	if !ok {
+		return nil
+	}
+	set := entry.(*mockSet)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if !set.dirty {
+		return nil
+	}
+
+	mocksPath := filepath.Join(dir, mocksFileName)
+	unlock := lockTestFile(mocksPath)
+	defer unlock()
+
+	code, err := set.code(mocksPath)
+	if err != nil {
+		return err
+	}
+	if err := emitTestFile(relativePath(mocksPath), code); err != nil {
+		return fmt.Errorf("failed to write %s: %v", mocksPath, err)
+	}
+	set.dirty = false
+	return nil
+}
+
+// flushAllMocks emits the mocks files of all the packages, in order
+func flushAllMocks() {
+	var dirs []string
+	packageMocks.Range(func(key, _ interface{}) bool {
+		dirs = append(dirs, key.(string))
+		return true
+	})
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		if err := flushMocks(dir); err != nil {
+			log.Errorf("Failed to emit the mocks: %v", err)
+		}
+	}
+}
+
+// relativePath returns the path relative to the current directory when possible, as the output
+// flags expect it
+func relativePath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"smart-testify/internal/loader"
+	"testing"
+)
+
+// newInterface declares an interface with a single method in the package
+func newInterface(pkg *types.Package, name string) *types.Named {
+	obj := types.NewTypeName(token.NoPos, pkg, name, nil)
+	sig := types.NewSignatureType(nil, nil, nil, nil, nil, false)
+	method := types.NewFunc(token.NoPos, pkg, "Do", sig)
+	return types.NewNamed(obj, types.NewInterfaceType([]*types.Func{method}, nil).Complete(), nil)
+}
+
+func TestMockName(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "svc.go", "package svc\n\ntype mockQueue struct{}\n\ntype Queue interface{ Do() }\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svcTypes, err := (&types.Config{}).Check("example.com/svc", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &loader.Package{Path: "example.com/svc", Name: "svc", Types: svcTypes}
+	repo := types.NewPackage("example.com/repo", "repo")
+	other := types.NewPackage("example.com/other", "other")
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		iface       *types.Named
+		wantPackage string
+		want        string
+	}{
+		{
+			name:        "free name",
+			iface:       newInterface(repo, "Store"),
+			wantPackage: "svc",
+			want:        "mockStore",
+		},
+		{
+			name:        "declared by the package",
+			iface:       newInterface(repo, "Queue"),
+			wantPackage: "svc",
+			want:        "mockRepoQueue",
+		},
+		{
+			name: "declared by a test file",
+			files: map[string]string{
+				"svc_test.go": "package svc\n\nfunc mockStore() {}\n\nvar mockRepoStore = 1\n",
+			},
+			iface:       newInterface(repo, "Store"),
+			wantPackage: "svc",
+			want:        "mockStore2",
+		},
+		{
+			name: "mock of the interface in the mocks file",
+			files: map[string]string{
+				"mocks_test.go": "package svc\n\n// mockStore is a testify mock of repo.Store\ntype mockStore struct{}\n",
+			},
+			iface:       newInterface(repo, "Store"),
+			wantPackage: "svc",
+			want:        "mockStore",
+		},
+		{
+			name: "mock of another interface in the mocks file",
+			files: map[string]string{
+				"mocks_test.go": "package svc\n\n// mockStore is a testify mock of other.Store\ntype mockStore struct{}\n",
+			},
+			iface:       newInterface(repo, "Store"),
+			wantPackage: "svc",
+			want:        "mockRepoStore",
+		},
+		{
+			name: "external test package of the test file",
+			files: map[string]string{
+				"svc_test.go":   "package svc_test\n\ntype mockOtherStore struct{}\n",
+				"other_test.go": "package svc\n\nfunc mockStore() {}\n",
+			},
+			iface:       newInterface(other, "Queue"),
+			wantPackage: "svc_test",
+			want:        "mockQueue",
+		},
+		{
+			name: "external test package of the mocks file",
+			files: map[string]string{
+				"mocks_test.go": "package svc_test\n\n// mockQueue is a testify mock of svc.Queue\ntype mockQueue struct{}\n",
+				"svc_test.go":   "package svc\n",
+			},
+			iface:       svcTypes.Scope().Lookup("Queue").Type().(*types.Named),
+			wantPackage: "svc_test",
+			want:        "mockQueue",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(dir, name), content)
+			}
+
+			var set mockSet
+			set.init(dir, filepath.Join(dir, "svc.go"), svc)
+			if set.packageName != tt.wantPackage {
+				t.Errorf("init() package = %s, want %s", set.packageName, tt.wantPackage)
+			}
+			if got := set.mockName(tt.iface); got != tt.want {
+				t.Errorf("mockName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultTestNameTemplate names the tests Test_Receiver_Method and Test_Function
+const defaultTestNameTemplate = "Test_{{if .Receiver}}{{.Receiver}}_{{end}}{{.Method}}"
+
+// testNameData is passed to the test name template
+type testNameData struct {
+	Receiver string // Receiver type of the method, empty for a function
+	Method   string // Name of the method or function
+}
+
+var testNameFuncs = template.FuncMap{
+	"upperFirst": upperFirst,
+	"lowerFirst": lowerFirst,
+}
+
+var (
+	testNameOnce     sync.Once
+	testNameTemplate *template.Template
+	testNameErr      error
+)
+
+var namingResetFlag bool
+
+// configNamingCmd shows or sets the template of the test function names
+var configNamingCmd = &cobra.Command{
+	Use:   "naming [template]",
+	Short: "Show or set the template of the test function names, e.g. 'Test{{.Receiver}}_{{upperFirst .Method}}'",
+	Long: `Show or set the template of the test function names. It is a Go text/template with the fields
+.Receiver (the receiver type, empty for functions) and .Method, and the functions upperFirst and lowerFirst.
+The default is ` + defaultTestNameTemplate + `.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			log.Errorf("Failed to load config: %v", err)
+			return
+		}
+
+		switch {
+		case namingResetFlag:
+			config.TestNameTemplate = ""
+		case len(args) == 0:
+			fmt.Println(effectiveTestNameTemplate(config))
+			return
+		default:
+			if _, err := parseTestNameTemplate(args[0]); err != nil {
+				log.Errorf("Invalid template: %v", err)
+				return
+			}
+			config.TestNameTemplate = args[0]
+		}
+
+		if err := saveConfig(config); err != nil {
+			log.Errorf("Failed to save config: %v", err)
+			return
+		}
+		fmt.Printf("Config updated: test name template set to %s\n", effectiveTestNameTemplate(config))
+	},
+}
+
+func effectiveTestNameTemplate(config *Config) string {
+	if config.TestNameTemplate == "" {
+		return defaultTestNameTemplate
+	}
+	return config.TestNameTemplate
+}
+
+// parseTestNameTemplate parses the template and checks that it renders valid test function names
+func parseTestNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("test name").Funcs(testNameFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse test name template: %v", err)
+	}
+
+	for _, data := range []testNameData{{Receiver: "Service", Method: "Get"}, {Method: "Get"}} {
+		name, err := renderTestName(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if !token.IsIdentifier(name) || !strings.HasPrefix(name, "Test") {
+			return nil, fmt.Errorf("test name template renders %q, which is not a valid test function name", name)
+		}
+	}
+	return tmpl, nil
+}
+
+func renderTestName(tmpl *template.Template, data testNameData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render test name template: %v", err)
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// receiverAndMethod returns the receiver type name, empty for a function, and the name of the method
+func receiverAndMethod(method *ast.FuncDecl) (string, string, error) {
+	if method.Recv == nil || len(method.Recv.List) == 0 {
+		return "", method.Name.Name, nil
+	}
+
+	pair, err := parseTypeDefination(method.Recv.List[0].Type)
+	if err != nil {
+		return "", "", err
+	}
+	if len(pair) == 0 {
+		return "", "", fmt.Errorf("receiver type not found")
+	}
+	return pair[0].TypeName, method.Name.Name, nil
+}
+
+// generateTestFuncName generates the test function name from the template configured for the project
+func generateTestFuncName(method *ast.FuncDecl) (string, error) {
+	receiver, methodName, err := receiverAndMethod(method)
+	if err != nil {
+		return "", err
+	}
+
+	testNameOnce.Do(func() {
+		testNameTemplate, testNameErr = parseTestNameTemplate(effectiveTestNameTemplate(getEffectiveConfig()))
+	})
+	if testNameErr != nil {
+		return "", testNameErr
+	}
+	return renderTestName(testNameTemplate, testNameData{Receiver: receiver, Method: methodName})
+}
+
+// testNameCandidates returns the names of a test of the method in the common conventions:
+// TestX and Test_X for functions, TestT_M, Test_T_M and TestT_m for methods
+func testNameCandidates(receiver, methodName string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	methods := []string{methodName, upperFirst(methodName), lowerFirst(methodName)}
+	if receiver == "" {
+		for _, m := range methods {
+			add("Test" + m)
+			add("Test_" + m)
+		}
+		return names
+	}
+
+	for _, r := range []string{receiver, upperFirst(receiver)} {
+		for _, m := range methods {
+			add("Test" + r + "_" + m)
+			add("Test_" + r + "_" + m)
+		}
+	}
+	return names
+}
+
+// findExistingTest returns the name of the existing test of the method, following the configured
+// convention first, then the common ones
+func findExistingTest(method *ast.FuncDecl, testFuncName string, existingTests map[string]*ast.FuncDecl) (string, bool) {
+	if _, exists := existingTests[testFuncName]; exists {
+		return testFuncName, true
+	}
+
+	receiver, methodName, err := receiverAndMethod(method)
+	if err != nil {
+		return "", false
+	}
+	for _, name := range testNameCandidates(receiver, methodName) {
+		if _, exists := existingTests[name]; exists {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// renameTestFunc renames the test function of the generated code when the model ignored the requested
+// name. It is only done when the code declares a single test function.
+func renameTestFunc(code, testFuncName string) string {
+	const header = "package p\n\n"
+	file, err := parser.ParseFile(token.NewFileSet(), "", header+code, 0)
+	if err != nil {
+		return code
+	}
+
+	var tests []*ast.FuncDecl
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") {
+			if fn.Name.Name == testFuncName {
+				return code
+			}
+			tests = append(tests, fn)
+		}
+	}
+	if len(tests) != 1 {
+		return code
+	}
+
+	name := tests[0].Name
+	offset := int(name.Pos()) - 1 - len(header)
+	log.Warnf("[%s] The model named the test function %s, renaming it", testFuncName, name.Name)
+	return code[:offset] + testFuncName + code[offset+len(name.Name):]
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
+func init() {
+	configNamingCmd.Flags().BoolVar(&namingResetFlag, "reset", false, "Reset the template to the default")
+}
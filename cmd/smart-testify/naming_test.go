@@ -0,0 +1,250 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+// parseFuncDecl parses the source of a single function or method declaration
+func parseFuncDecl(t *testing.T, src string) *ast.FuncDecl {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+src, 0)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", src, err)
+	}
+	return file.Decls[0].(*ast.FuncDecl)
+}
+
+func TestRenderTestName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		method   string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:   "function",
+			method: "func Sum(a, b int) int { return a + b }",
+			want:   "Test_Sum",
+		},
+		{
+			name:   "pointer receiver",
+			method: "func (s *Service) Get(id string) error { return nil }",
+			want:   "Test_Service_Get",
+		},
+		{
+			name:   "generic receiver",
+			method: "func (s *Stack[T]) Push(v T) {}",
+			want:   "Test_Stack_Push",
+		},
+		{
+			name:   "generic receiver with several type parameters",
+			method: "func (m Map[K, V]) Get(key K) V { return m[key] }",
+			want:   "Test_Map_Get",
+		},
+		{
+			name:   "generic function",
+			method: "func Filter[T any](s []T, keep func(T) bool) []T { return nil }",
+			want:   "Test_Filter",
+		},
+		{
+			name:     "custom template with an unexported method",
+			template: "Test{{.Receiver}}_{{upperFirst .Method}}",
+			method:   "func (c *cache) evict() {}",
+			want:     "Testcache_Evict",
+		},
+		{
+			name:     "custom template for a generic function",
+			template: "Test{{if .Receiver}}{{.Receiver}}{{end}}{{upperFirst .Method}}",
+			method:   "func mapKeys[K comparable, V any](m map[K]V) []K { return nil }",
+			want:     "TestMapKeys",
+		},
+		{
+			name:     "template rendering an invalid name",
+			template: "Test {{.Method}}",
+			wantErr:  "not a valid test function name",
+		},
+		{
+			name:     "template without the Test prefix",
+			template: "{{.Method}}Test",
+			wantErr:  "not a valid test function name",
+		},
+		{
+			name:     "invalid template",
+			template: "Test{{.Method",
+			wantErr:  "failed to parse test name template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := tt.template
+			if text == "" {
+				text = defaultTestNameTemplate
+			}
+			tmpl, err := parseTestNameTemplate(text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTestNameTemplate() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTestNameTemplate() error = %v", err)
+			}
+
+			receiver, method, err := receiverAndMethod(parseFuncDecl(t, tt.method))
+			if err != nil {
+				t.Fatalf("receiverAndMethod() error = %v", err)
+			}
+			got, err := renderTestName(tmpl, testNameData{Receiver: receiver, Method: method})
+			if err != nil {
+				t.Fatalf("renderTestName() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTestName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindExistingTest(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		testFuncName string
+		existing     []string
+		want         string
+		wantFound    bool
+	}{
+		{
+			name:         "name of the template is taken",
+			method:       "func (s *Service) Get() {}",
+			testFuncName: "Test_Service_Get",
+			existing:     []string{"TestService_Get", "Test_Service_Get"},
+			want:         "Test_Service_Get",
+			wantFound:    true,
+		},
+		{
+			name:         "common convention",
+			method:       "func (s *Service) Get() {}",
+			testFuncName: "Test_Service_Get",
+			existing:     []string{"TestService_Get"},
+			want:         "TestService_Get",
+			wantFound:    true,
+		},
+		{
+			name:         "function",
+			method:       "func Sum(a, b int) int { return a + b }",
+			testFuncName: "Test_Sum",
+			existing:     []string{"TestSum"},
+			want:         "TestSum",
+			wantFound:    true,
+		},
+		{
+			name:         "unexported method of an unexported type",
+			method:       "func (c *cache) evict() {}",
+			testFuncName: "Test_cache_evict",
+			existing:     []string{"TestCache_Evict"},
+			want:         "TestCache_Evict",
+			wantFound:    true,
+		},
+		{
+			name:         "generic receiver",
+			method:       "func (s *Stack[T]) Push(v T) {}",
+			testFuncName: "Test_Stack_Push",
+			existing:     []string{"TestStack_Push"},
+			want:         "TestStack_Push",
+			wantFound:    true,
+		},
+		{
+			name:         "test of another receiver",
+			method:       "func (s *Stack[T]) Push(v T) {}",
+			testFuncName: "Test_Stack_Push",
+			existing:     []string{"TestQueue_Push", "TestPush"},
+		},
+		{
+			name:         "test of a method with the same name",
+			method:       "func Push(v int) {}",
+			testFuncName: "Test_Push",
+			existing:     []string{"TestStack_Push"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existingTests := make(map[string]*ast.FuncDecl)
+			for _, name := range tt.existing {
+				existingTests[name] = parseFuncDecl(t, "func "+name+"(t *testing.T) {}")
+			}
+			got, found := findExistingTest(parseFuncDecl(t, tt.method), tt.testFuncName, existingTests)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("findExistingTest() = %q, %v, want %q, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestRenameTestFunc(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		testFuncName string
+		want         string
+	}{
+		{
+			name:         "model ignored the name",
+			code:         "func TestGet(t *testing.T) {\n\tt.Run(\"TestGet\", nil)\n}",
+			testFuncName: "Test_Service_Get",
+			want:         "func Test_Service_Get(t *testing.T) {\n\tt.Run(\"TestGet\", nil)\n}",
+		},
+		{
+			name:         "requested name",
+			code:         "func Test_Service_Get(t *testing.T) {}",
+			testFuncName: "Test_Service_Get",
+			want:         "func Test_Service_Get(t *testing.T) {}",
+		},
+		{
+			name:         "requested name among other tests",
+			code:         "func TestHelper(t *testing.T) {}\n\nfunc Test_Service_Get(t *testing.T) {}",
+			testFuncName: "Test_Service_Get",
+			want:         "func TestHelper(t *testing.T) {}\n\nfunc Test_Service_Get(t *testing.T) {}",
+		},
+		{
+			name:         "several tests",
+			code:         "func TestA(t *testing.T) {}\n\nfunc TestB(t *testing.T) {}",
+			testFuncName: "Test_Service_Get",
+			want:         "func TestA(t *testing.T) {}\n\nfunc TestB(t *testing.T) {}",
+		},
+		{
+			name:         "helpers and methods are not tests",
+			code:         "type fake struct{}\n\nfunc (fake) TestLike() {}\n\nfunc newFake() fake { return fake{} }\n\nfunc TestStack_Push(t *testing.T) {}",
+			testFuncName: "Test_Stack_Push",
+			want:         "type fake struct{}\n\nfunc (fake) TestLike() {}\n\nfunc newFake() fake { return fake{} }\n\nfunc Test_Stack_Push(t *testing.T) {}",
+		},
+		{
+			name:         "generic helper",
+			code:         "func ptr[T any](v T) *T { return &v }\n\nfunc TestFilter(t *testing.T) { _ = ptr(1) }",
+			testFuncName: "Test_Filter",
+			want:         "func ptr[T any](v T) *T { return &v }\n\nfunc Test_Filter(t *testing.T) { _ = ptr(1) }",
+		},
+		{
+			name:         "invalid code",
+			code:         "func TestGet(t *testing.T) {",
+			testFuncName: "Test_Get",
+			want:         "func TestGet(t *testing.T) {",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renameTestFunc(tt.code, tt.testFuncName); got != tt.want {
+				t.Errorf("renameTestFunc() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
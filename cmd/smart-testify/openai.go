@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	openaiBaseURL       string
+	openaiAPIKey        string
+	openaiModel         string
+	openaiTemperature   float64
+	openaiMaxTokens     int
+	openaiContextTokens int
+	openaiStream        bool
+)
+
+var openaiCmd = &cobra.Command{
+	Use:   "openai",
+	Short: "Configure settings for OpenAI compatible chat completions endpoints.",
+}
+
+var openaiSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set the endpoint, API key and model settings, only the given flags are updated",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			log.Errorf("Failed to load config: %v", err)
+			return
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("base-url") {
+			config.OpenAI.BaseURL = openaiBaseURL
+		}
+		if flags.Changed("api-key") {
+			config.OpenAI.APIKey = openaiAPIKey
+		}
+		if flags.Changed("model") {
+			config.OpenAI.Model = openaiModel
+		}
+		if flags.Changed("temperature") {
+			config.OpenAI.Temperature = openaiTemperature
+		}
+		if flags.Changed("max-tokens") {
+			config.OpenAI.MaxTokens = openaiMaxTokens
+		}
+		if flags.Changed("context-tokens") {
+			config.OpenAI.ContextTokens = openaiContextTokens
+		}
+		if flags.Changed("stream") {
+			config.OpenAI.Stream = openaiStream
+		}
+
+		if err := saveConfig(config); err != nil {
+			log.Errorf("Failed to save config: %v", err)
+			return
+		}
+		fmt.Println("Config updated: OpenAI settings saved")
+	},
+}
+
+func init() {
+	openaiSetCmd.Flags().StringVar(&openaiBaseURL, "base-url", "", "Base URL of the endpoint, e.g. https://api.openai.com/v1")
+	openaiSetCmd.Flags().StringVar(&openaiAPIKey, "api-key", "", "API key sent as the Bearer token")
+	openaiSetCmd.Flags().StringVar(&openaiModel, "model", "", "Name of the model, e.g. gpt-4o")
+	openaiSetCmd.Flags().Float64Var(&openaiTemperature, "temperature", 0, "Sampling temperature")
+	openaiSetCmd.Flags().IntVar(&openaiMaxTokens, "max-tokens", 0, "Maximum number of tokens in the completion, 0 means the server default")
+	openaiSetCmd.Flags().IntVar(&openaiContextTokens, "context-tokens", 0, "Context window of the model in tokens, 0 means unknown")
+	openaiSetCmd.Flags().BoolVar(&openaiStream, "stream", false, "Stream the completion as server-sent events")
+
+	openaiCmd.AddCommand(openaiSetCmd)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"smart-testify/internal/util"
+	"strings"
+	"sync"
+)
+
+var (
+	dryRunFlag    bool
+	diffFlag      bool
+	outputDirFlag string
+)
+
+// outputMu keeps the files and diffs printed by concurrent jobs from interleaving
+var outputMu sync.Mutex
+
+// writesInPlace reports whether the generated test files replace the ones of the working copy
+func writesInPlace() bool {
+	return !dryRunFlag && !diffFlag && outputDirFlag == ""
+}
+
+// emitTestFile runs goimports on the code, then writes it to the test file, prints it or its diff
+// with the current test file, or writes it to the output directory
+func emitTestFile(testFilePath, code string) error {
+	formatted, err := util.FormatImports(testFilePath, code)
+	if err != nil {
+		log.Warnf("Failed to run goimports for %s due to %s", testFilePath, err)
+		formatted = code
+	}
+
+	switch {
+	case diffFlag:
+		oldName := "a/" + filepath.ToSlash(testFilePath)
+		original, err := ioutil.ReadFile(testFilePath)
+		if os.IsNotExist(err) {
+			oldName = "/dev/null"
+		} else if err != nil {
+			return err
+		}
+
+		diff := util.UnifiedDiff(oldName, "b/"+filepath.ToSlash(testFilePath), string(original), formatted)
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		fmt.Print(diff)
+		return nil
+	case dryRunFlag:
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		fmt.Printf("// File: %s\n%s\n", testFilePath, formatted)
+		return nil
+	case outputDirFlag != "":
+		target, err := outputPath(testFilePath)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := writeTestFile(target, formatted); err != nil {
+			return err
+		}
+		log.Infof("Wrote %s", target)
+		return nil
+	default:
+		return writeTestFile(testFilePath, formatted)
+	}
+}
+
+// outputPath mirrors the path of the test file, relative to the current directory, in the output directory
+func outputPath(testFilePath string) (string, error) {
+	absPath, err := filepath.Abs(testFilePath)
+	if err != nil {
+		return "", err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wd, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the current directory, it can't be mirrored in the output directory", testFilePath)
+	}
+	return filepath.Join(outputDirFlag, rel), nil
+}
+
+// writeOverlay writes the code of each file to a temporary file and an overlay replacing the files with them,
+// for the go command to see the files without writing them in place
+func writeOverlay(files map[string]string) (overlayPath string, cleanup func(), err error) {
+	dir, err := ioutil.TempDir("", "smart-testify-overlay-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	replace := make(map[string]string, len(files))
+	for path, code := range files {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		// Prefixed by the index, the files of different packages may have the same name
+		codePath := filepath.Join(dir, fmt.Sprintf("%d_%s", len(replace), filepath.Base(path)))
+		if err := ioutil.WriteFile(codePath, []byte(code), 0644); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		replace[absPath] = codePath
+	}
+
+	overlay, err := json.Marshal(map[string]map[string]string{
+		"Replace": replace,
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	overlayPath = filepath.Join(dir, "overlay.json")
+	if err := ioutil.WriteFile(overlayPath, overlay, 0644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return overlayPath, cleanup, nil
+}
@@ -144,28 +144,45 @@ var promptSetDefaultCmd = &cobra.Command{
 	},
 }
 
-const defaultPrompt = `The output must meet below conditions. 
-1. Should include success and failure cases, and include edge cases. When DB operation is involved, you should include db error. Make your best to cover 100 percent of the code. 
-2. When it involve gorm DB operations, you should start sqlite in memory to mock it. For example gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+// defaultPrompt is a text/template, the rules for gorm, gomonkey, generics and the mocks only apply to the functions which need them,
+// gomonkey is only suggested when there are no mocks to use instead
+const defaultPrompt = `The output must meet below conditions.
+- Should include success and failure cases, and include edge cases. Make your best to cover 100 percent of the code.
+- Name the test function exactly as requested above.
+- For each function you generated, you should include a comment to declare this function is generated by AI.
+- Be attention to the case-sensitivity of the code.
+- You should generate different cases in format like t.Run("test name", func(t *testing.T) { ... }) for each case.
+- You should use github.com/stretchr/testify/assert to do the assertion. For example, assert.Equal(t, expected, actual).
+{{- if hasImport "time"}}
+- When it involves time.Time, you should create it by fakeTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+{{- end}}
+{{- if hasImport "gorm.io/gorm"}}
+- The function uses gorm, you should start sqlite in memory to mock it. For example gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{SingularTable: true},
 	})
-3. When function has receiver, you should include it in the test name. For example, TestLuContentRatingDao_GetALL for function GetALL in LuContentRatingDao.
-4. For each function you generated, you should include a comment to declare this function is generated by AI.
-5. When it involves DB error case, you can simulate DB error by closing the DB connection. For example, sqlDB, _ := db.DB() sqlDB.Close(). In addition, you should put this case in the last to avoid affecting other cases.
-6. When it involves time.Time, you should create it by fakeTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
-7. Be attention to the case-sensitivity of the code.
-8. When DB AutoMigrate failed, you should to report the error.
-9. You should generate different cases in format like t.Run("test name", func(t *testing.T) { ... }) for each case.
-10. You should use github.com/stretchr/testify/assert to do the assertion. For example, assert.Equal(t, expected, actual).
-11. When you need to mock functions, For example, validateOnCreateLuNF, you can use github.com/agiledragon/gomonkey/v2 to mock it. For example,
+- When DB AutoMigrate failed, you should to report the error.
+- You should include a DB error case. You can simulate DB error by closing the DB connection. For example, sqlDB, _ := db.DB() sqlDB.Close(). In addition, you should put this case in the last to avoid affecting other cases.
+{{- end}}
+{{- if and .Callees (not .Mocks)}}
+- When you need to mock the called functions ({{join .Callees ", "}}), you can use github.com/agiledragon/gomonkey/v2. For example,
 				patches := gomonkey.NewPatches()
-				patches.ApplyFuncReturn(validateOnCreateLuNF, nil)
+				patches.ApplyFuncReturn({{index .Callees 0}}, nil)
 				defer patches.Reset()
+{{- end}}
+{{- if .TypeParams}}
+- The function is generic, instantiate it with concrete types satisfying the constraints, e.g. {{if .Receiver}}{{.Receiver}}[int]{{else}}{{.FuncName}}[int]{{end}} when int does, and cover each kind of type argument its behavior depends on. Spell out the type arguments when they can't be inferred.
+{{- end}}
+{{- if .Mocks}}
+- Use the testify mocks listed above for the interfaces instead of patching their methods, create them in each case so the expectations don't leak.
+{{- end}}
 `
 
-const newPrompt = `The output must meet below conditions. 
-1. Should include success and failure cases, and include edge cases. When DB operation is involved, you should include db error. Make your best to cover 100 percent of the code. 
-2. When function has receiver, you should include it in the test name. For example, TestLuContentRatingDao_GetALL for function GetALL in LuContentRatingDao.
+// newPrompt is the content of the prompts created by 'prompt add', it is a text/template rendered for each function
+const newPrompt = `{{/* Rendered for each function with .FuncName, .Receiver, .Package, .ImportPath, .Imports, .Callees,
+.Mocks, .TypeParams, .TestFuncName, .ExistingTests and .GoVersion, e.g. {{if hasImport "gorm.io/gorm"}}...{{end}} */ -}}
+The output must meet below conditions.
+1. Should include success and failure cases, and include edge cases. Make your best to cover 100 percent of the code.
+2. Name the test function exactly as requested above.
 3. For each function you generated, you should include a comment to declare this function is generated by AI.
 4. Be attention to the case-sensitivity of the code.
 5. You should generate different cases in format like t.Run("test name", func(t *testing.T) { ... }) for each case.
@@ -283,6 +300,19 @@ func setDefaultPrompt(name string) error {
 	return ioutil.WriteFile(getDefaultPromptPath(), []byte(name), 0644)
 }
 
+// loadConfiguredPrompt loads the prompt file or the named prompt set in the config, or the default prompt
+func loadConfiguredPrompt() (string, error) {
+	config := getEffectiveConfig()
+	if config.PromptFile != "" {
+		content, err := ioutil.ReadFile(config.PromptFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read prompt file: %v", err)
+		}
+		return string(content), nil
+	}
+	return loadPrompt(config.Prompt)
+}
+
 func loadPrompt(name string) (string, error) {
 	if name == "" {
 		var err error
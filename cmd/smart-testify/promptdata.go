@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"runtime"
+	"smart-testify/internal/mockgen"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// promptWrapperTemplate wraps the custom prompt with the function to test and its context
+const promptWrapperTemplate = `Generate unit tests for below function:
+import (
+{{range .Imports}}	{{printf "%q" .}}
+{{end}})
+
+{{.Source}}
+
+The related types and functions definition code is:
+{{.TypeDefs}}
+{{if .Mocks}}
+The following testify mocks are declared in mocks_test.go of the package, don't declare them again:
+{{range .Mocks}}- {{.Name}} mocks {{.Interface}}:
+{{range .Methods}}	{{.}}
+{{end}}{{end}}Set their expectations with On("Method", args...).Return(results...) and check them with AssertExpectations(t).
+{{end}}{{if .TypeParams}}
+The function is generic, its type parameters are: {{join .TypeParams ", "}}.
+{{end}}{{if .CoverageHint}}
+{{.CoverageHint}}
+{{end}}You should only output the test function, nothing else. Don't output the package declaration, imports, or any other code.
+The test function name should be {{.TestFuncName}}.
+
+{{.Prompt}}
+`
+
+// promptData is passed to the prompt templates
+type promptData struct {
+	FuncName      string          // Name of the function to test
+	Receiver      string          // Receiver type of the method, without the pointer and type parameters, empty for functions
+	TypeParams    []string        // Type parameters of the receiver, then of the function with their constraints, e.g. T, K comparable
+	Package       string          // Package name of the source file
+	ImportPath    string          // Import path of the package, empty outside of a module
+	Imports       []string        // Import paths of the source file
+	TypeDefs      string          // Definitions of the related types and functions, cut to fit the prompt
+	Callees       []string        // Functions called by the function, qualified by their package, e.g. gorm.Open
+	TestFuncName  string          // Name of the test function to generate
+	ExistingTests []string        // Test functions already in the test file
+	GoVersion     string          // Go version of the module, e.g. 1.20
+	Source        string          // Source of the function
+	Mocks         []*mockgen.Mock // Testify mocks of the interfaces the function depends on, with --mocks
+	CoverageHint  string          // Uncovered lines to focus on, with --coverage-target
+	Prompt        string          // Rendered custom prompt, only set for the wrapper
+}
+
+// funcs returns the helpers of the prompt templates, bound to the data
+func (d *promptData) funcs() template.FuncMap {
+	return template.FuncMap{
+		"hasImport": func(path string) bool { return containsString(d.Imports, path) },
+		"hasCallee": func(name string) bool { return containsString(d.Callees, name) },
+		"hasTest":   func(name string) bool { return containsString(d.ExistingTests, name) },
+		"hasMock": func(name string) bool {
+			for _, mock := range d.Mocks {
+				if mock.Interface == name {
+					return true
+				}
+			}
+			return false
+		},
+		"join":      strings.Join,
+		"contains":  strings.Contains,
+		"hasPrefix": strings.HasPrefix,
+		"hasSuffix": strings.HasSuffix,
+	}
+}
+
+// newPromptData collects the variables of the prompt templates for the method, except TypeDefs
+func newPromptData(fset *token.FileSet, method *ast.FuncDecl, filePath, testFuncName, coverageHint string, existingTests []string) (*promptData, error) {
+	// Only the package clause and the imports are needed, the declarations come from the method
+	file, err := parser.ParseFile(token.NewFileSet(), filePath, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+
+	var source bytes.Buffer
+	if err := format.Node(&source, fset, method); err != nil {
+		return nil, fmt.Errorf("failed to generate source code for %s due to %s", method.Name.Name, err.Error())
+	}
+
+	receiver, _, err := receiverAndMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &promptData{
+		FuncName:      method.Name.Name,
+		Receiver:      receiver,
+		Package:       file.Name.Name,
+		TestFuncName:  testFuncName,
+		ExistingTests: existingTests,
+		Source:        source.String(),
+		CoverageHint:  coverageHint,
+		TypeParams:    typeParamList(method),
+	}
+
+	for _, imp := range file.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+			data.Imports = append(data.Imports, path)
+		}
+	}
+
+	usedFunctions, _, err := collectTypesAndFunctionsFromBody(method.Body)
+	if err != nil {
+		return nil, err
+	}
+	sortFunctionPairs(usedFunctions)
+	for _, funcDef := range usedFunctions {
+		data.Callees = append(data.Callees, qualifiedName(funcDef.PackageName, funcDef.FuncName))
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	data.GoVersion = strings.TrimPrefix(runtime.Version(), "go")
+	if root := findModuleRoot(dir); root != "" {
+		modulePath, goVersion := readGoMod(filepath.Join(root, "go.mod"))
+		if goVersion != "" {
+			data.GoVersion = goVersion
+		}
+		if modulePath != "" {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return nil, err
+			}
+			data.ImportPath = strings.TrimSuffix(modulePath+"/"+filepath.ToSlash(rel), "/.")
+		}
+	}
+	return data, nil
+}
+
+// typeParamList returns the type parameters of the generic receiver, whose constraints are declared with the
+// receiver type, then the type parameters of the function with their constraints
+func typeParamList(method *ast.FuncDecl) []string {
+	var list []string
+	for _, param := range receiverTypeParams(method) {
+		list = append(list, types.ExprString(param))
+	}
+	if method.Type.TypeParams != nil {
+		for _, field := range method.Type.TypeParams.List {
+			constraint := types.ExprString(field.Type)
+			for _, name := range field.Names {
+				list = append(list, name.Name+" "+constraint)
+			}
+		}
+	}
+	return list
+}
+
+// renderPrompt renders the custom prompt, then the wrapper around it. A custom prompt which doesn't parse as
+// a template, e.g. one with a composite literal such as []T{{...}}, is used as is.
+func renderPrompt(customPrompt string, data promptData) (string, error) {
+	custom := customPrompt
+	if tmpl, err := parsePromptTemplate("prompt", customPrompt, &data); err != nil {
+		log.Debugf("Prompt is not a valid template, using it as is: %v", err)
+	} else if custom, err = executePromptTemplate(tmpl, &data); err != nil {
+		return "", err
+	}
+	data.Prompt = custom
+
+	tmpl, err := parsePromptTemplate("wrapper", promptWrapperTemplate, &data)
+	if err != nil {
+		return "", err
+	}
+	return executePromptTemplate(tmpl, &data)
+}
+
+func parsePromptTemplate(name, text string, data *promptData) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(data.funcs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+func executePromptTemplate(tmpl *template.Template, data *promptData) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %v", tmpl.Name(), err)
+	}
+	return out.String(), nil
+}
+
+// readGoMod returns the module path and the Go version declared in the go.mod file
+func readGoMod(path string) (string, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer file.Close()
+
+	var modulePath, goVersion string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "module":
+			modulePath = strings.Trim(fields[1], `"`)
+		case "go":
+			goVersion = fields[1]
+		}
+	}
+	return modulePath, goVersion
+}
+
+// sortedTestNames returns the names of the existing test functions in order
+func sortedTestNames(existingTests map[string]*ast.FuncDecl) []string {
+	names := make([]string, 0, len(existingTests))
+	for name := range existingTests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"smart-testify/internal/mockgen"
+	"strings"
+	"testing"
+)
+
+func TestRenderPrompt(t *testing.T) {
+	data := promptData{
+		FuncName:      "Save",
+		Receiver:      "Repo",
+		Package:       "repo",
+		Imports:       []string{"context", "gorm.io/gorm"},
+		TypeDefs:      "type Repo struct{ db *gorm.DB }",
+		Callees:       []string{"gorm.Open", "validate"},
+		TestFuncName:  "Test_Repo_Save",
+		ExistingTests: []string{"Test_Repo_Get"},
+		Source:        "func (r *Repo) Save(ctx context.Context) error { return nil }",
+		Mocks: []*mockgen.Mock{{
+			Name:      "mockStore",
+			Interface: "repo.Store",
+			Methods:   []string{"Put(key string) error"},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		prompt  string
+		data    func(d *promptData)
+		want    []string // Parts of the rendered prompt, in order
+		wantNot []string
+		wantErr string
+	}{
+		{
+			name:   "plain prompt",
+			prompt: "Use table driven tests.",
+			want: []string{
+				"import (\n\t\"context\"\n\t\"gorm.io/gorm\"\n)",
+				data.Source,
+				"The related types and functions definition code is:\n" + data.TypeDefs,
+				"- mockStore mocks repo.Store:\n\tPut(key string) error\n",
+				"The test function name should be Test_Repo_Save.",
+				"Use table driven tests.",
+			},
+			wantNot: []string{"The function is generic", "{{"},
+		},
+		{
+			name:   "fields",
+			prompt: "Test {{.Receiver}}.{{.FuncName}} of package {{.Package}}.",
+			want:   []string{"Test Repo.Save of package repo."},
+		},
+		{
+			name:    "hasImport",
+			prompt:  `{{if hasImport "gorm.io/gorm"}}Use sqlite in memory.{{end}}{{if hasImport "database/sql"}}Use sqlmock.{{end}}`,
+			want:    []string{"Use sqlite in memory."},
+			wantNot: []string{"Use sqlmock."},
+		},
+		{
+			name:    "hasCallee",
+			prompt:  `{{if hasCallee "gorm.Open"}}Stub gorm.Open.{{end}}{{if hasCallee "Open"}}Stub Open.{{end}}`,
+			want:    []string{"Stub gorm.Open."},
+			wantNot: []string{"Stub Open."},
+		},
+		{
+			name:    "hasTest",
+			prompt:  `{{if hasTest "Test_Repo_Get"}}Reuse the fixtures of Test_Repo_Get.{{end}}{{if hasTest "Test_Repo_Save"}}Append cases.{{end}}`,
+			want:    []string{"Reuse the fixtures of Test_Repo_Get."},
+			wantNot: []string{"Append cases."},
+		},
+		{
+			name:    "hasMock",
+			prompt:  `{{if hasMock "repo.Store"}}Use mockStore.{{end}}{{if hasMock "Store"}}Unqualified.{{end}}`,
+			want:    []string{"Use mockStore."},
+			wantNot: []string{"Unqualified."},
+		},
+		{
+			name:    "string helpers",
+			prompt:  `{{join .Callees " and "}}{{if contains .Source "ctx"}}, with a context{{end}}{{if hasPrefix .FuncName "Sa"}}, prefixed{{end}}{{if hasSuffix .FuncName "x"}}, suffixed{{end}}`,
+			want:    []string{"gorm.Open and validate, with a context, prefixed"},
+			wantNot: []string{"suffixed"},
+		},
+		{
+			name:   "generic function",
+			prompt: "Cover the type parameters.",
+			data: func(d *promptData) {
+				d.TypeParams = []string{"K comparable", "V any"}
+				d.Mocks = nil
+			},
+			want:    []string{"The function is generic, its type parameters are: K comparable, V any.", "Cover the type parameters."},
+			wantNot: []string{"testify mocks"},
+		},
+		{
+			name:   "coverage hint",
+			prompt: "Focus.",
+			data:   func(d *promptData) { d.CoverageHint = "Lines 10-12 are not covered." },
+			want:   []string{"Lines 10-12 are not covered.", "Focus."},
+		},
+		{
+			name:   "composite literal is not a template",
+			prompt: "Build the cases as []testCase{{name: \"empty\"}}, and {{ if you like }}.",
+			want:   []string{"Build the cases as []testCase{{name: \"empty\"}}, and {{ if you like }}."},
+		},
+		{
+			name:   "unclosed action is not a template",
+			prompt: "Use map[string]int{{\"a\": 1} as input.",
+			want:   []string{"Use map[string]int{{\"a\": 1} as input."},
+		},
+		{
+			name:    "execution error",
+			prompt:  "{{.Unknown}}",
+			wantErr: "failed to render prompt template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := data
+			if tt.data != nil {
+				tt.data(&d)
+			}
+			got, err := renderPrompt(tt.prompt, d)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("renderPrompt() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderPrompt() error = %v", err)
+			}
+
+			rest := got
+			for _, part := range tt.want {
+				i := strings.Index(rest, part)
+				if i < 0 {
+					t.Fatalf("renderPrompt() = %q, want it to contain %q after the previous parts", got, part)
+				}
+				rest = rest[i+len(part):]
+			}
+			for _, part := range tt.wantNot {
+				if strings.Contains(got, part) {
+					t.Errorf("renderPrompt() = %q, want it not to contain %q", got, part)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderPromptContextPlaceholder(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		want   int
+	}{
+		{name: "wrapper only", prompt: "Use table driven tests.", want: 1},
+		{name: "custom prompt using the context", prompt: "Here are the types again:\n{{.TypeDefs}}", want: 2},
+		{name: "prompt which is not a template", prompt: "[]T{{...}} {{.TypeDefs}}", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPrompt(tt.prompt, promptData{TypeDefs: contextPlaceholder, TestFuncName: "TestX"})
+			if err != nil {
+				t.Fatalf("renderPrompt() error = %v", err)
+			}
+			if copies := strings.Count(got, contextPlaceholder); copies != tt.want {
+				t.Errorf("renderPrompt() has %d copies of the context, want %d", copies, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeParamList(t *testing.T) {
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{method: "func Sum(a, b int) int { return a + b }", want: nil},
+		{method: "func (s Set[T]) Add(v T) {}", want: []string{"T"}},
+		{method: "func (s *Set[T]) Add(v T) {}", want: []string{"T"}},
+		{method: "func (s *(Set[T])) Add(v T) {}", want: []string{"T"}},
+		{method: "func (m (*Map[K, V])) Get(key K) V { var v V; return v }", want: []string{"K", "V"}},
+		{method: "func Keys[K comparable, V any](m map[K]V) []K { return nil }", want: []string{"K comparable", "V any"}},
+		{method: "func Convert[S ~[]E, E any, R fmt.Stringer](s S) R { var r R; return r }", want: []string{"S ~[]E", "E any", "R fmt.Stringer"}},
+	}
+
+	for _, tt := range tests {
+		got := typeParamList(parseFuncDecl(t, tt.method))
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("typeParamList(%s) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"smart-testify/internal/customhttp"
+	"smart-testify/internal/local"
+	"smart-testify/internal/openai"
+	"smart-testify/internal/provider"
+	"smart-testify/internal/twinkle"
+)
+
+// currentProvider is the provider used to generate test cases, it is created lazily from the config
+var currentProvider provider.Provider
+
+// getProvider returns the provider selected by `smart-testify config use`
+func getProvider() (provider.Provider, error) {
+	if currentProvider == nil {
+		p, err := provider.New(getEffectiveConfig().Model)
+		if err != nil {
+			return nil, err
+		}
+		// Every backend gets the same retry, backoff and timeout handling
+		retrier = provider.NewRetrier(p, getRetryConfig())
+		currentProvider = retrier
+	}
+
+	return currentProvider, nil
+}
+
+func init() {
+	// Register the built-in providers, new backends only need to be registered here
+	provider.Register(modelCopilot, func() (provider.Provider, error) {
+		return getCopilotClient(), nil
+	})
+	provider.Register(modelTwinkle, func() (provider.Provider, error) {
+		return twinkle.NewClient(getEffectiveConfig().Twinkle)
+	})
+	provider.Register(modelOpenAI, func() (provider.Provider, error) {
+		return openai.NewClient(getEffectiveConfig().OpenAI), nil
+	})
+	provider.Register(modelLocal, func() (provider.Provider, error) {
+		return local.NewClient(getEffectiveConfig().Local), nil
+	})
+	provider.Register(modelCustom, func() (provider.Provider, error) {
+		return customhttp.NewClient(getEffectiveConfig().Custom)
+	})
+}
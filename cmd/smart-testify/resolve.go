@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"smart-testify/internal/budget"
+	"smart-testify/internal/loader"
+	"sync"
+)
+
+// defaultContextDepth follows the types of the fields of the types referenced by the function, but not theirs
+const defaultContextDepth = 1
+
+var contextDepthFlag int
+
+// packageLoader type-checks the package of each source file once, it is shared by the jobs
+var packageLoader = loader.New()
+
+// loadWarnings remembers the packages whose load errors were already reported, keyed by directory
+var loadWarnings sync.Map
+
+// resolveDefinitions collects the declarations referenced by the method from the type-checked package,
+// ranked like generateTypeDefinitionSectionCode, with the types of the receiver fields whose methods are
+// called right after the signature types, and the nested types last
+func resolveDefinitions(fset *token.FileSet, method *ast.FuncDecl, filePath string) ([]budget.Item, error) {
+	dir := filepath.Dir(filePath)
+	pkg, err := packageLoader.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkg.Errors) > 0 {
+		if _, warned := loadWarnings.LoadOrStore(dir, true); !warned {
+			log.Warnf("Package %s has errors, its context may be incomplete: %v", pkg.Path, pkg.Errors[0])
+		}
+	}
+
+	fn, err := packageLoader.FindFunc(pkg, filePath, fset.Position(method.Name.Pos()).Line, method.Name.Name)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := packageLoader.References(pkg, fn, contextDepthFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []budget.Item
+	for _, decl := range refs.SignatureTypes {
+		items = append(items, typeItem(decl.Package, decl.Name, decl.Source, prioritySignatureType))
+	}
+	for _, decl := range refs.Dependencies {
+		item := typeItem(decl.Package, decl.Name, decl.Source, priorityDependency)
+		header := fmt.Sprintf("Field: %s\n", decl.Field)
+		item.Full = header + item.Full
+		if item.Summary != "" {
+			item.Summary = header + item.Summary
+		}
+		items = append(items, item)
+	}
+	for _, decl := range refs.Callees {
+		name := decl.Name
+		if decl.Recv != "" {
+			name = decl.Recv + "." + decl.Name
+		}
+		items = append(items, funcItem(decl.Package, name, decl.Source))
+	}
+	for _, decl := range refs.BodyTypes {
+		items = append(items, typeItem(decl.Package, decl.Name, decl.Source, priorityBodyType))
+	}
+	for _, decl := range refs.NestedTypes {
+		items = append(items, nestedTypeItem(decl.Package, decl.Name, decl.Source, decl.Via, decl.Depth))
+	}
+	return items, nil
+}
+
+// typeItem is the context item of a type definition
+func typeItem(packageName, typeName, source string, priority int) budget.Item {
+	var header string
+	if packageName == "" {
+		header = fmt.Sprintf("Model: %s\nDefinition:\n", typeName)
+	} else {
+		header = fmt.Sprintf("Package: %s \nModel: %s\nDefinition:\n", packageName, typeName)
+	}
+
+	item := budget.Item{
+		Name:     qualifiedName(packageName, typeName),
+		Priority: priority,
+		Full:     header + source + "\n",
+	}
+	if summary := summarizeType(source); summary != "" {
+		item.Summary = header + summary + "\n"
+	}
+	return item
+}
+
+// nestedTypeItem is the context item of a type referenced by the definition of the type via
+func nestedTypeItem(packageName, typeName, source, via string, depth int) budget.Item {
+	item := typeItem(packageName, typeName, source, priorityNestedType+depth-1)
+	header := fmt.Sprintf("Referenced by: %s\n", via)
+	item.Full = header + item.Full
+	if item.Summary != "" {
+		item.Summary = header + item.Summary
+	}
+	return item
+}
+
+// funcItem is the context item of a called function
+func funcItem(packageName, funcName, source string) budget.Item {
+	header := fmt.Sprintf("Package: %s \nMethod: %s\n", packageName, funcName)
+	item := budget.Item{
+		Name:     qualifiedName(packageName, funcName),
+		Priority: priorityCallee,
+		Full:     header + source + "\n",
+	}
+	if signature := summarizeFunction(source); signature != "" {
+		item.Summary = header + signature + "\n"
+	}
+	return item
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"smart-testify/internal/provider"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxAttempts    = 3
+	defaultRequestTimeout = 5 * time.Minute
+)
+
+var (
+	maxAttemptsFlag    int
+	requestTimeoutFlag time.Duration
+)
+
+// retrier wraps the provider selected in the config, it is kept to report the statistics
+var retrier *provider.Retrier
+
+// getRetryConfig merges the generate flags over the config and the defaults
+func getRetryConfig() provider.RetryConfig {
+	config := provider.RetryConfig{
+		MaxAttempts: defaultMaxAttempts,
+		Timeout:     defaultRequestTimeout,
+	}
+
+	retry := getEffectiveConfig().Retry
+	if retry.MaxAttempts > 0 {
+		config.MaxAttempts = retry.MaxAttempts
+	}
+	if retry.RequestTimeoutSeconds > 0 {
+		config.Timeout = time.Duration(retry.RequestTimeoutSeconds) * time.Second
+	}
+
+	if maxAttemptsFlag > 0 {
+		config.MaxAttempts = maxAttemptsFlag
+	}
+	if requestTimeoutFlag > 0 {
+		config.Timeout = requestTimeoutFlag
+	}
+	return config
+}
+
+// reportRetryStats logs how many model calls were made and retried during the run
+func reportRetryStats() {
+	if retrier == nil {
+		return
+	}
+
+	stats := retrier.Stats()
+	if stats.Calls == 0 {
+		return
+	}
+
+	var reasons []string
+	for reason, count := range stats.Reasons {
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, count))
+	}
+	sort.Strings(reasons)
+
+	log.Infof("Model calls: %d, attempts: %d, retries: %d, failed: %d, waited: %v",
+		stats.Calls, stats.Attempts, stats.Retries, stats.Failures, stats.Waited.Round(time.Millisecond))
+	if len(reasons) > 0 {
+		log.Infof("Retry reasons: %s", strings.Join(reasons, ", "))
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// testFileTemplateName is looked up in the .smart-testify directory of the module, then of the home directory
+const testFileTemplateName = "test_file.tmpl"
+
+// defaultTestFileTemplate is used when neither the project nor the user has a template
+const defaultTestFileTemplate = `{{with .License}}{{.}}
+
+{{end}}{{with .BuildTags}}//go:build {{.}}
+
+{{end}}// Code generated by AI.
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+`
+
+// testFileData is passed to the test file template
+type testFileData struct {
+	Package   string   // Package name of the source file
+	BuildTags string   // Build constraint of the source file, e.g. "linux && amd64"
+	License   string   // Comments before the package clause of the source file, e.g. a license header
+	Imports   []string // Imports used by the generated code, e.g. "testing" or null "github.com/volatiletech/null/v9"
+}
+
+// wellKnownImports resolves the packages commonly used by the generated tests which the source file doesn't import
+var wellKnownImports = map[string]string{
+	"testing":  "testing",
+	"assert":   "github.com/stretchr/testify/assert",
+	"require":  "github.com/stretchr/testify/require",
+	"mock":     "github.com/stretchr/testify/mock",
+	"suite":    "github.com/stretchr/testify/suite",
+	"gomonkey": "github.com/agiledragon/gomonkey/v2",
+}
+
+var testFileInitFlag bool
+
+// configTestFileCmd shows the test file template which applies to the current directory
+var configTestFileCmd = &cobra.Command{
+	Use:   "test-file",
+	Short: "Show the template of the new test files, or create it in the project with --init",
+	Long: `Show the Go text/template used to create new test files. It is looked up at the test_file_template path
+of the config, then in .smart-testify/` + testFileTemplateName + ` at the root of the module,
+then in ~/.smart-testify/` + testFileTemplateName + `,
+and defaults to a built-in template. It is rendered with .Package, .BuildTags, .License and .Imports.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Errorf("Failed to get the current directory: %v", err)
+			return
+		}
+
+		if testFileInitFlag {
+			root := findModuleRoot(wd)
+			if root == "" {
+				log.Errorf("No go.mod found in %s or its parents", wd)
+				return
+			}
+			path := filepath.Join(root, ".smart-testify", testFileTemplateName)
+			if fileExists(path) {
+				log.Errorf("Template %s already exists", path)
+				return
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				log.Errorf("Failed to create directory: %v", err)
+				return
+			}
+			if err := ioutil.WriteFile(path, []byte(defaultTestFileTemplate), 0644); err != nil {
+				log.Errorf("Failed to write template: %v", err)
+				return
+			}
+			fmt.Printf("Created %s\n", path)
+			return
+		}
+
+		content, origin, err := loadTestFileTemplate(wd)
+		if err != nil {
+			log.Errorf("Failed to load template: %v", err)
+			return
+		}
+		fmt.Printf("# %s\n%s", origin, content)
+	},
+}
+
+// loadTestFileTemplate returns the template which applies to the directory and where it comes from
+func loadTestFileTemplate(dir string) (string, string, error) {
+	var paths []string
+	if path := getEffectiveConfig().TestFileTemplate; path != "" {
+		paths = append(paths, path)
+	}
+	if root := findModuleRoot(dir); root != "" {
+		paths = append(paths, filepath.Join(root, ".smart-testify", testFileTemplateName))
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(homeDir, ".smart-testify", testFileTemplateName))
+	}
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return string(content), path, nil
+	}
+	return defaultTestFileTemplate, "built-in", nil
+}
+
+// findModuleRoot returns the closest directory containing a go.mod, or an empty string
+func findModuleRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if fileExists(filepath.Join(dir, "go.mod")) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// newTestFile renders the header of a new test file for the source file and the generated code
+func newTestFile(sourcePath string, source *ast.File, generatedCode []string) (string, error) {
+	content, origin, err := loadTestFileTemplate(filepath.Dir(sourcePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to load test file template: %v", err)
+	}
+	tmpl, err := template.New(testFileTemplateName).Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse test file template %s: %v", origin, err)
+	}
+
+	license, buildTags := sourceHeader(sourcePath)
+	data := testFileData{
+		Package:   source.Name.Name,
+		BuildTags: buildTags,
+		License:   license,
+		Imports:   generatedImports(source, generatedCode),
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render test file template %s: %v", origin, err)
+	}
+	return sb.String() + "\n", nil
+}
+
+// sourceHeader returns the comments before the package clause of the source file, except the
+// package documentation and the build constraints, and its build constraint
+func sourceHeader(sourcePath string) (string, string) {
+	file, err := parser.ParseFile(token.NewFileSet(), sourcePath, nil, parser.ParseComments|parser.PackageClauseOnly)
+	if err != nil {
+		return "", ""
+	}
+
+	var license []string
+	var buildTags string
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package || group == file.Doc {
+			continue
+		}
+
+		var lines []string
+		for _, comment := range group.List {
+			switch {
+			case strings.HasPrefix(comment.Text, "//go:build "):
+				buildTags = strings.TrimSpace(strings.TrimPrefix(comment.Text, "//go:build "))
+			case strings.HasPrefix(comment.Text, "// +build "):
+			default:
+				lines = append(lines, comment.Text)
+			}
+		}
+		if len(lines) > 0 {
+			license = append(license, strings.Join(lines, "\n"))
+		}
+	}
+	return strings.Join(license, "\n\n"), buildTags
+}
+
+// generatedImports returns the imports of the packages referenced by the generated code, resolved with
+// the imports of the source file and the well-known test packages. The others are left to goimports.
+func generatedImports(source *ast.File, generatedCode []string) []string {
+	majorVersion := regexp.MustCompile(`^v\d+$`)
+	sourceImports := make(map[string]*ast.ImportSpec)
+	for _, imp := range source.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(path)
+		if majorVersion.MatchString(name) {
+			name = filepath.Base(filepath.Dir(path))
+		}
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != "_" && name != "." {
+			sourceImports[name] = imp
+		}
+	}
+
+	used := map[string]bool{`"testing"`: true}
+	for _, code := range generatedCode {
+		file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+code, 0)
+		if err != nil {
+			continue
+		}
+		unresolved := make(map[*ast.Ident]bool, len(file.Unresolved))
+		for _, ident := range file.Unresolved {
+			unresolved[ident] = true
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			ident, ok := sel.X.(*ast.Ident)
+			if !ok || !unresolved[ident] {
+				return true
+			}
+			if imp, ok := sourceImports[ident.Name]; ok {
+				if imp.Name != nil {
+					used[imp.Name.Name+" "+imp.Path.Value] = true
+				} else {
+					used[imp.Path.Value] = true
+				}
+			} else if path, ok := wellKnownImports[ident.Name]; ok {
+				used[strconv.Quote(path)] = true
+			}
+			return true
+		})
+	}
+
+	imports := make([]string, 0, len(used))
+	for imp := range used {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+func init() {
+	configTestFileCmd.Flags().BoolVar(&testFileInitFlag, "init", false, "Create the template in the .smart-testify directory of the module, from the built-in one")
+}
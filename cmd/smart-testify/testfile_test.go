@@ -0,0 +1,228 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTestFileTemplate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	module := t.TempDir()
+	writeFile(t, filepath.Join(module, "go.mod"), "module example.com/m\n")
+	configured := filepath.Join(t.TempDir(), "configured.tmpl")
+
+	saved := effectiveConfig
+	defer func() { effectiveConfig = saved }()
+
+	tests := []struct {
+		name       string
+		configured bool // Whether the config sets the path of the template
+		files      map[string]string
+		want       string
+	}{
+		{
+			name: "built-in",
+			want: defaultTestFileTemplate,
+		},
+		{
+			name:  "home",
+			files: map[string]string{filepath.Join(home, ".smart-testify", testFileTemplateName): "home"},
+			want:  "home",
+		},
+		{
+			name: "module over home",
+			files: map[string]string{
+				filepath.Join(home, ".smart-testify", testFileTemplateName):   "home",
+				filepath.Join(module, ".smart-testify", testFileTemplateName): "module",
+			},
+			want: "module",
+		},
+		{
+			name:       "configured path over module",
+			configured: true,
+			files: map[string]string{
+				filepath.Join(module, ".smart-testify", testFileTemplateName): "module",
+				configured: "configured",
+			},
+			want: "configured",
+		},
+		{
+			name:       "missing configured path",
+			configured: true,
+			files:      map[string]string{filepath.Join(module, ".smart-testify", testFileTemplateName): "module"},
+			want:       "module",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for path, content := range tt.files {
+				writeFile(t, path, content)
+				defer os.Remove(path)
+			}
+			effectiveConfig = &Config{}
+			if tt.configured {
+				effectiveConfig.TestFileTemplate = configured
+			}
+
+			got, _, err := loadTestFileTemplate(filepath.Join(module, "pkg"))
+			if err != nil {
+				t.Fatalf("loadTestFileTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("loadTestFileTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		code   []string
+		want   []string
+	}{
+		{
+			name:   "testing only",
+			source: `import "strings"`,
+			code:   []string{"func TestX(t *testing.T) {}"},
+			want:   []string{`"testing"`},
+		},
+		{
+			name:   "import of the source file",
+			source: `import "strings"`,
+			code:   []string{`func TestX(t *testing.T) { _ = strings.ToUpper("a") }`},
+			want:   []string{`"strings"`, `"testing"`},
+		},
+		{
+			name:   "aliased import",
+			source: `import null "github.com/volatiletech/null/v9"`,
+			code:   []string{"func TestX(t *testing.T) { _ = null.StringFrom(\"a\") }"},
+			want:   []string{`"testing"`, `null "github.com/volatiletech/null/v9"`},
+		},
+		{
+			name:   "alias differing from the package name",
+			source: `import sq "github.com/Masterminds/squirrel"`,
+			code:   []string{"func TestX(t *testing.T) { _ = sq.Select(\"a\") }"},
+			want:   []string{`"testing"`, `sq "github.com/Masterminds/squirrel"`},
+		},
+		{
+			name:   "major version path",
+			source: `import "github.com/go-redis/redis/v8"`,
+			code:   []string{"func TestX(t *testing.T) { _ = redis.Nil }"},
+			want:   []string{`"github.com/go-redis/redis/v8"`, `"testing"`},
+		},
+		{
+			name:   "well-known packages",
+			source: `import "strings"`,
+			code: []string{
+				"func TestX(t *testing.T) { assert.True(t, true) }",
+				"func TestY(t *testing.T) { p := gomonkey.ApplyFunc(nil, nil); defer p.Reset(); require.NoError(t, nil) }",
+			},
+			want: []string{`"github.com/agiledragon/gomonkey/v2"`, `"github.com/stretchr/testify/assert"`, `"github.com/stretchr/testify/require"`, `"testing"`},
+		},
+		{
+			name:   "local variable shadowing a package",
+			source: `import "strings"`,
+			code:   []string{"func TestX(t *testing.T) { strings := struct{ N int }{}; _ = strings.N }"},
+			want:   []string{`"testing"`},
+		},
+		{
+			name:   "blank and dot imports",
+			source: "import (\n\t_ \"embed\"\n\t. \"fmt\"\n)",
+			code:   []string{"func TestX(t *testing.T) { _ = embed.FS{} }"},
+			want:   []string{`"testing"`},
+		},
+		{
+			name:   "invalid code",
+			source: `import "strings"`,
+			code:   []string{"func TestX(t *testing.T) { strings.ToUpper("},
+			want:   []string{`"testing"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+tt.source+"\n", parser.ImportsOnly)
+			if err != nil {
+				t.Fatalf("failed to parse source: %v", err)
+			}
+			got := generatedImports(source, tt.code)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("generatedImports() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        string
+		wantLicense   string
+		wantBuildTags string
+	}{
+		{
+			name:   "no header",
+			source: "package p\n",
+		},
+		{
+			name:        "license",
+			source:      "// Copyright 2024 The Authors.\n// SPDX-License-Identifier: MIT\n\npackage p\n",
+			wantLicense: "// Copyright 2024 The Authors.\n// SPDX-License-Identifier: MIT",
+		},
+		{
+			name:        "block license",
+			source:      "/*\nCopyright 2024 The Authors.\n*/\n\npackage p\n",
+			wantLicense: "/*\nCopyright 2024 The Authors.\n*/",
+		},
+		{
+			name:          "build constraint",
+			source:        "//go:build linux && amd64\n// +build linux,amd64\n\npackage p\n",
+			wantBuildTags: "linux && amd64",
+		},
+		{
+			name:          "license and build constraint",
+			source:        "// Copyright 2024 The Authors.\n\n//go:build !windows\n\npackage p\n",
+			wantLicense:   "// Copyright 2024 The Authors.",
+			wantBuildTags: "!windows",
+		},
+		{
+			name:        "package documentation is not a license",
+			source:      "// Copyright 2024 The Authors.\n\n// Package p does things.\npackage p\n\n// Other is after the package clause\nvar Other int\n",
+			wantLicense: "// Copyright 2024 The Authors.",
+		},
+		{
+			name:   "invalid file",
+			source: "// Copyright\npackage\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "p.go")
+			writeFile(t, path, tt.source)
+			license, buildTags := sourceHeader(path)
+			if license != tt.wantLicense || buildTags != tt.wantBuildTags {
+				t.Errorf("sourceHeader() = %q, %q, want %q, %q", license, buildTags, tt.wantLicense, tt.wantBuildTags)
+			}
+		})
+	}
+}
+
+// writeFile writes the file and its missing parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var twinkleAuthHeader string
+
+var twinkleCmd = &cobra.Command{
+	Use:   "twinkle",
+	Short: "Configure settings for Twinkle.",
+}
+
+var twinkleSetEndpointCmd = &cobra.Command{
+	Use:   "set-endpoint <url>",
+	Short: "Set the URL of the Twinkle API",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		endpoint := args[0]
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Errorf("Invalid endpoint: %s, must be an absolute URL such as https://twinkle.example.com/api/completion", endpoint)
+			return
+		}
+
+		if err := updateTwinkleConfig(func(config *Config) {
+			config.Twinkle.Endpoint = endpoint
+		}); err != nil {
+			log.Errorf("Failed to update config: %v", err)
+			return
+		}
+		fmt.Printf("Config updated: twinkle endpoint set to %s\n", endpoint)
+	},
+}
+
+var twinkleSetAuthCmd = &cobra.Command{
+	Use:   "set-auth <token>",
+	Short: "Set the token sent with each request, it is sent verbatim so include the scheme if needed (e.g. \"Bearer xxx\")",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := updateTwinkleConfig(func(config *Config) {
+			config.Twinkle.AuthToken = args[0]
+			config.Twinkle.AuthHeader = twinkleAuthHeader
+		}); err != nil {
+			log.Errorf("Failed to update config: %v", err)
+			return
+		}
+		fmt.Printf("Config updated: twinkle token will be sent in header %s\n", twinkleAuthHeader)
+	},
+}
+
+var twinkleSetTimeoutCmd = &cobra.Command{
+	Use:   "set-timeout <seconds>",
+	Short: "Set the timeout of each request in seconds, 0 means the default of 120 seconds",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds < 0 {
+			log.Errorf("Invalid timeout: %s, must be a non-negative number of seconds", args[0])
+			return
+		}
+
+		if err := updateTwinkleConfig(func(config *Config) {
+			config.Twinkle.TimeoutSeconds = seconds
+		}); err != nil {
+			log.Errorf("Failed to update config: %v", err)
+			return
+		}
+		fmt.Printf("Config updated: twinkle timeout set to %d seconds\n", seconds)
+	},
+}
+
+var twinkleSetHeaderCmd = &cobra.Command{
+	Use:   "set-header <name> [value]",
+	Short: "Add an extra header sent with each request, omit the value to remove the header",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := updateTwinkleConfig(func(config *Config) {
+			if len(args) == 1 {
+				delete(config.Twinkle.Headers, name)
+				return
+			}
+			if config.Twinkle.Headers == nil {
+				config.Twinkle.Headers = make(map[string]string)
+			}
+			config.Twinkle.Headers[name] = args[1]
+		}); err != nil {
+			log.Errorf("Failed to update config: %v", err)
+			return
+		}
+
+		if len(args) == 1 {
+			fmt.Printf("Config updated: twinkle header %s removed\n", name)
+		} else {
+			fmt.Printf("Config updated: twinkle header %s set\n", name)
+		}
+	},
+}
+
+// updateTwinkleConfig loads the config, applies the update and saves it
+func updateTwinkleConfig(update func(config *Config)) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	update(config)
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	twinkleSetAuthCmd.Flags().StringVar(&twinkleAuthHeader, "header", "Authorization", "Header used to send the token")
+
+	twinkleCmd.AddCommand(twinkleSetEndpointCmd)
+	twinkleCmd.AddCommand(twinkleSetAuthCmd)
+	twinkleCmd.AddCommand(twinkleSetTimeoutCmd)
+	twinkleCmd.AddCommand(twinkleSetHeaderCmd)
+}
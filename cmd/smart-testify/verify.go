@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"smart-testify/internal/budget"
+	"smart-testify/internal/util"
+	"strings"
+	"sync"
+)
+
+const (
+	onFailureDrop       = "drop"
+	onFailureQuarantine = "quarantine"
+
+	defaultMaxRepairAttempts = 2
+
+	// quarantineBuildTag excludes the quarantined tests from the normal builds
+	quarantineBuildTag = "smarttestify_quarantine"
+
+	// maxRepairOutput bounds the go vet/test output sent back to the model
+	maxRepairOutput = 4000
+
+	verifyTestTimeout = "2m"
+)
+
+var (
+	verifyFlag            bool
+	maxRepairAttemptsFlag int
+	onFailureFlag         string
+)
+
+// packageLocks serializes the verifications of the same package, keyed by directory
+var packageLocks sync.Map
+
+// lockPackage locks the package in dir and returns the function to unlock it
+func lockPackage(dir string) func() {
+	lock, _ := packageLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// verifyGeneratedTests appends the tests to code one by one, keeping those that pass go vet and
+// go test. A failing test is sent back to the model with the output up to --max-repair-attempts
+// times, then it is dropped or quarantined. It returns the code of the test file with the kept tests.
+func verifyGeneratedTests(ctx context.Context, testFilePath, packageName, code string, tests []generatedTest) (string, error) {
+	dir := filepath.Dir(testFilePath)
+	unlock := lockPackage(dir)
+	defer unlock()
+
+	// A package which is already broken can't tell anything about the generated tests
+	if output, err := checkTestFile(ctx, testFilePath, code, nil); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		log.Warnf("Package %s doesn't pass go vet before adding the generated tests, skipping verification: %v\n%s", dir, err, output)
+		for _, test := range tests {
+			code = appendTestCode(code, test.Code)
+		}
+		return code, nil
+	}
+
+	for _, test := range tests {
+		candidate := test.Code
+		for attempt := 0; ; attempt++ {
+			merged, err := mergeTestCode(code, candidate)
+			var output string
+			if err == nil {
+				output, err = checkTestFile(ctx, testFilePath, merged, testFuncNames(candidate))
+				if err == nil {
+					log.Infof("[%s] Verified", test.TestFuncName)
+					code = merged
+					break
+				}
+			} else {
+				output = err.Error()
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			if attempt >= maxRepairAttemptsFlag {
+				log.Warnf("[%s] Still failing after %d repair attempts:\n%s", test.TestFuncName, attempt, output)
+				if err := discardTest(testFilePath, packageName, test.TestFuncName, candidate); err != nil {
+					return "", err
+				}
+				break
+			}
+
+			log.Infof("[%s] Verification failed, asking the model to repair it, attempt %d of %d", test.TestFuncName, attempt+1, maxRepairAttemptsFlag)
+			repaired, err := repairTest(ctx, test, candidate, output)
+			if err != nil {
+				if ctx.Err() != nil {
+					return "", ctx.Err()
+				}
+				log.Warnf("[%s] Failed to repair: %v", test.TestFuncName, err)
+				if err := discardTest(testFilePath, packageName, test.TestFuncName, candidate); err != nil {
+					return "", err
+				}
+				break
+			}
+			candidate = repaired
+		}
+	}
+	return code, nil
+}
+
+// checkTestFile runs go vet on the package and the given tests, with the code in place of the test file
+func checkTestFile(ctx context.Context, testFilePath, code string, testNames []string) (string, error) {
+	formatted, err := util.FormatImports(testFilePath, code)
+	if err != nil {
+		log.Warnf("Failed to run goimports for %s due to %s", testFilePath, err)
+		formatted = code
+	}
+
+	files := map[string]string{testFilePath: formatted}
+	// The mocks the test functions use may not be written yet
+	mocksPath, mocksCode, ok, err := pendingMocks(filepath.Dir(testFilePath))
+	if err != nil {
+		log.Warnf("Failed to generate the mocks for %s due to %s", testFilePath, err)
+	} else if ok && filepath.Base(testFilePath) != mocksFileName {
+		files[mocksPath] = mocksCode
+	}
+
+	overlayPath, cleanup, err := writeOverlay(files)
+	if err != nil {
+		return "", fmt.Errorf("failed to write overlay: %v", err)
+	}
+	defer cleanup()
+
+	dir := filepath.Dir(testFilePath)
+	if output, err := util.RunGoCommand(ctx, dir, "vet", "-overlay="+overlayPath, "."); err != nil {
+		return output, fmt.Errorf("go vet failed: %v", err)
+	}
+	if len(testNames) == 0 {
+		return "", nil
+	}
+
+	quoted := make([]string, len(testNames))
+	for i, name := range testNames {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	run := "^(" + strings.Join(quoted, "|") + ")$"
+	if output, err := util.RunGoCommand(ctx, dir, "test", "-overlay="+overlayPath, "-count=1", "-timeout", verifyTestTimeout, "-run", run, "."); err != nil {
+		return output, fmt.Errorf("go test failed: %v", err)
+	}
+	return "", nil
+}
+
+// repairTest sends the failing test and the output back to the model and returns the fixed test
+func repairTest(ctx context.Context, test generatedTest, code, output string) (string, error) {
+	output = truncateOutput(output, maxRepairOutput)
+
+	// The failing code and the instructions are kept, the original prompt is dropped first to fit the model limit
+	head := "You generated the following test function:\n```go\n" + code + "\n```\n"
+	tail := fmt.Sprintf(`
+Fix the test function and output it in full. You should only output the test function, nothing else. Don't output the package declaration, imports, or any other code.
+The test function name should be %s.
+`, test.TestFuncName)
+	failure := "It fails with the following go vet or go test output:\n" + output
+	items := []budget.Item{
+		{Name: "failure output", Priority: 0, Full: failure, Summary: truncateOutput(failure, maxRepairOutput/4)},
+		{Name: "original prompt", Priority: 1, Full: "It was generated for the following request:\n" + test.Prompt},
+	}
+	fitted, err := fitContext(test.TestFuncName, items, head+tail, 1)
+	if err != nil {
+		return "", err
+	}
+	prompt := head + "\n" + fitted + "\n" + tail
+
+	if err := acquireJobSlot(ctx); err != nil {
+		return "", err
+	}
+	defer releaseJobSlot()
+
+	code, err = chatForCode(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return renameTestFunc(code, test.TestFuncName), nil
+}
+
+// truncateOutput cuts the output to max bytes
+func truncateOutput(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	return output[:max] + "\n..."
+}
+
+// discardTest drops the test or moves it to the quarantine file, depending on --on-failure
+func discardTest(testFilePath, packageName, testFuncName, code string) error {
+	if onFailureFlag != onFailureQuarantine {
+		log.Warnf("[%s] Dropped", testFuncName)
+		return nil
+	}
+
+	quarantinePath := strings.TrimSuffix(testFilePath, "_test.go") + "_quarantine_test.go"
+	var content string
+	if existing, err := ioutil.ReadFile(quarantinePath); err == nil {
+		content = string(existing) + "\n"
+	} else {
+		content = fmt.Sprintf("//go:build %s\n\n// Tests generated by smart-testify which failed to build or pass, run them with -tags %s\n\npackage %s\n\n",
+			quarantineBuildTag, quarantineBuildTag, packageName)
+	}
+	content = appendTestCode(content, code)
+
+	if err := emitTestFile(quarantinePath, content); err != nil {
+		return fmt.Errorf("Failed to write to quarantine file %s: %v", quarantinePath, err)
+	}
+	log.Warnf("[%s] Quarantined in %s", testFuncName, quarantinePath)
+	return nil
+}
+
+// testFuncNames returns the names of the test functions declared in the code
+func testFuncNames(code string) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+code, 0)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") {
+			names = append(names, fn.Name.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+var jobsFlag int
+
+// jobSlots bounds how many prompts are generated and sent to the model at the same time
+var jobSlots chan struct{}
+
+// testFileLocks serializes the writes to the same test file, keyed by path
+var testFileLocks sync.Map
+
+// initJobSlots sizes the worker pool, it must be called before any file is processed
+func initJobSlots(jobs int) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	jobSlots = make(chan struct{}, jobs)
+}
+
+// acquireJobSlot blocks until a worker is free or the context is cancelled
+func acquireJobSlot(ctx context.Context) error {
+	select {
+	case jobSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseJobSlot() {
+	<-jobSlots
+}
+
+// lockTestFile locks the test file and returns the function to unlock it
+func lockTestFile(testFilePath string) func() {
+	lock, _ := testFileLocks.LoadOrStore(testFilePath, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// taskGroup runs tasks concurrently and cancels the others on the first error
+type taskGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	once sync.Once
+	err  error
+}
+
+func newTaskGroup(ctx context.Context) *taskGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	return &taskGroup{ctx: ctx, cancel: cancel}
+}
+
+// Go runs the task in a new goroutine
+func (g *taskGroup) Go(task func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := task(g.ctx); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait waits for all tasks and returns the first error
+func (g *taskGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
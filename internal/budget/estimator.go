@@ -0,0 +1,54 @@
+package budget
+
+import (
+	"math"
+	"strings"
+)
+
+// defaultCharsPerToken is used for unknown models, it is low on purpose to over-estimate
+const defaultCharsPerToken = 3.0
+
+// charsPerToken is the average number of bytes of Go source per token, by model name prefix
+var charsPerToken = []struct {
+	prefix string
+	ratio  float64
+}{
+	{"gpt-4o", 3.6},
+	{"gpt-4.1", 3.6},
+	{"o1", 3.6},
+	{"o3", 3.6},
+	{"o4", 3.6},
+	{"gpt-4", 3.2},
+	{"gpt-3.5", 3.2},
+	{"claude", 3.2},
+	{"qwen", 3.3},
+	{"deepseek", 3.3},
+	{"llama", 3.1},
+	{"mistral", 3.0},
+	{"codellama", 3.0},
+}
+
+// Estimator estimates how many tokens a model needs for a text without a tokenizer
+type Estimator struct {
+	CharsPerToken float64
+}
+
+// NewEstimator returns the estimator of the model, falling back to a conservative default
+func NewEstimator(model string) Estimator {
+	model = strings.ToLower(model)
+	for _, entry := range charsPerToken {
+		if strings.HasPrefix(model, entry.prefix) {
+			return Estimator{CharsPerToken: entry.ratio}
+		}
+	}
+	return Estimator{CharsPerToken: defaultCharsPerToken}
+}
+
+// Count returns the estimated number of tokens of the text
+func (e Estimator) Count(text string) int {
+	ratio := e.CharsPerToken
+	if ratio <= 0 {
+		ratio = defaultCharsPerToken
+	}
+	return int(math.Ceil(float64(len(text)) / ratio))
+}
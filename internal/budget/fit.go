@@ -0,0 +1,89 @@
+package budget
+
+import (
+	"sort"
+	"strings"
+)
+
+// Item is a piece of context that can be shortened or dropped to fit the budget
+type Item struct {
+	Name     string // Used to report what was cut, e.g. "model.User"
+	Priority int    // Lower values are more important and are cut last
+	Full     string // The complete text
+	Summary  string // A shorter text, e.g. a signature without body, empty if it can't be shortened
+}
+
+// Result is the context assembled from the items that fit
+type Result struct {
+	Text      string
+	Tokens    int
+	Shortened []string // Names of the items replaced by their summary
+	Dropped   []string // Names of the items left out
+}
+
+// Fit joins the items with sep, cutting the least important ones until the text fits in limit tokens.
+// Items of the lowest priority are first shortened to their summary, then dropped, before moving on
+// to the next priority. A negative limit means no limit.
+func Fit(items []Item, limit int, estimator Estimator, sep string) Result {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	const (
+		stateFull = iota
+		stateSummary
+		stateDropped
+	)
+	states := make([]int, len(sorted))
+
+	assemble := func() string {
+		var parts []string
+		for i, item := range sorted {
+			switch states[i] {
+			case stateFull:
+				parts = append(parts, item.Full)
+			case stateSummary:
+				parts = append(parts, item.Summary)
+			}
+		}
+		return strings.Join(parts, sep)
+	}
+
+	text := assemble()
+	fits := func() bool {
+		return limit < 0 || estimator.Count(text) <= limit
+	}
+
+	// Walk from the least important item to the most important one, shortening first and then dropping
+	for end := len(sorted); end > 0 && !fits(); {
+		start := end - 1
+		for start > 0 && sorted[start-1].Priority == sorted[end-1].Priority {
+			start--
+		}
+
+		for i := end - 1; i >= start && !fits(); i-- {
+			if sorted[i].Summary != "" && len(sorted[i].Summary) < len(sorted[i].Full) {
+				states[i] = stateSummary
+				text = assemble()
+			}
+		}
+		for i := end - 1; i >= start && !fits(); i-- {
+			states[i] = stateDropped
+			text = assemble()
+		}
+		end = start
+	}
+
+	result := Result{Text: text, Tokens: estimator.Count(text)}
+	for i, item := range sorted {
+		switch states[i] {
+		case stateSummary:
+			result.Shortened = append(result.Shortened, item.Name)
+		case stateDropped:
+			result.Dropped = append(result.Dropped, item.Name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,157 @@
+package budget
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Priorities of the context the generate command uses, lower values are cut last
+const (
+	prioritySignature = iota
+	priorityDependency
+	priorityCallee
+	priorityBody
+	priorityNested
+)
+
+// byteEstimator counts one token per byte, so the limits below are lengths
+var byteEstimator = Estimator{CharsPerToken: 1}
+
+func TestFit(t *testing.T) {
+	// Given out of order, the text is assembled by priority
+	items := []Item{
+		{Name: "nested", Priority: priorityNested, Full: "NNNNNNNN", Summary: "NN"},
+		{Name: "callee", Priority: priorityCallee, Full: "CCCCCCCC", Summary: "CC"},
+		{Name: "signature", Priority: prioritySignature, Full: "SSSS"},
+		{Name: "body", Priority: priorityBody, Full: "BBBB"},
+		{Name: "dependency", Priority: priorityDependency, Full: "DDDDDDDD", Summary: "DD"},
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  Result
+	}{
+		{
+			name:  "no limit",
+			limit: -1,
+			want:  Result{Text: "SSSS\nDDDDDDDD\nCCCCCCCC\nBBBB\nNNNNNNNN", Tokens: 36},
+		},
+		{
+			name:  "exact fit",
+			limit: 36,
+			want:  Result{Text: "SSSS\nDDDDDDDD\nCCCCCCCC\nBBBB\nNNNNNNNN", Tokens: 36},
+		},
+		{
+			name:  "nested types are shortened first",
+			limit: 35,
+			want:  Result{Text: "SSSS\nDDDDDDDD\nCCCCCCCC\nBBBB\nNN", Tokens: 30, Shortened: []string{"nested"}},
+		},
+		{
+			name:  "then dropped",
+			limit: 29,
+			want:  Result{Text: "SSSS\nDDDDDDDD\nCCCCCCCC\nBBBB", Tokens: 27, Dropped: []string{"nested"}},
+		},
+		{
+			name:  "body types without summary are dropped",
+			limit: 26,
+			want:  Result{Text: "SSSS\nDDDDDDDD\nCCCCCCCC", Tokens: 22, Dropped: []string{"body", "nested"}},
+		},
+		{
+			name:  "callees are shortened",
+			limit: 17,
+			want:  Result{Text: "SSSS\nDDDDDDDD\nCC", Tokens: 16, Shortened: []string{"callee"}, Dropped: []string{"body", "nested"}},
+		},
+		{
+			name:  "callees are dropped",
+			limit: 15,
+			want:  Result{Text: "SSSS\nDDDDDDDD", Tokens: 13, Dropped: []string{"callee", "body", "nested"}},
+		},
+		{
+			name:  "dependencies are shortened",
+			limit: 8,
+			want:  Result{Text: "SSSS\nDD", Tokens: 7, Shortened: []string{"dependency"}, Dropped: []string{"callee", "body", "nested"}},
+		},
+		{
+			name:  "the signature types are kept the longest",
+			limit: 6,
+			want:  Result{Text: "SSSS", Tokens: 4, Dropped: []string{"dependency", "callee", "body", "nested"}},
+		},
+		{
+			name:  "nothing fits",
+			limit: 3,
+			want:  Result{Text: "", Tokens: 0, Dropped: []string{"signature", "dependency", "callee", "body", "nested"}},
+		},
+		{
+			name:  "zero budget",
+			limit: 0,
+			want:  Result{Text: "", Tokens: 0, Dropped: []string{"signature", "dependency", "callee", "body", "nested"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fit(items, tt.limit, byteEstimator, "\n"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitShortensBeforeDropping(t *testing.T) {
+	items := []Item{
+		{Name: "a", Priority: priorityCallee, Full: "AAAAAAAA", Summary: "AA"},
+		{Name: "b", Priority: priorityCallee, Full: "BBBBBBBB", Summary: "BB"},
+		{Name: "c", Priority: priorityCallee, Full: "CCCC", Summary: "CCCCCC"}, // Longer summary, never used
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  Result
+	}{
+		{
+			name:  "the last item is shortened first",
+			limit: 20,
+			want:  Result{Text: "AAAAAAAA\nBB\nCCCC", Tokens: 16, Shortened: []string{"b"}},
+		},
+		{
+			name:  "every item of the priority is shortened before any is dropped",
+			limit: 10,
+			want:  Result{Text: "AA\nBB\nCCCC", Tokens: 10, Shortened: []string{"a", "b"}},
+		},
+		{
+			name:  "then the last items are dropped",
+			limit: 5,
+			want:  Result{Text: "AA\nBB", Tokens: 5, Shortened: []string{"a", "b"}, Dropped: []string{"c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fit(items, tt.limit, byteEstimator, "\n"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatorCount(t *testing.T) {
+	tests := []struct {
+		model string
+		text  string
+		want  int
+	}{
+		{model: "gpt-4o-mini", text: "1234567", want: 2},                           // 3.6 bytes per token
+		{model: "GPT-4", text: "1234567", want: 3},                                 // 3.2 bytes per token, case-insensitive
+		{model: "unknown", text: "1234567", want: 3},                               // Conservative default of 3
+		{model: "codellama:7b", text: "1234567890123456789012345678901", want: 11}, // codellama, not llama
+		{model: "", text: "", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := NewEstimator(tt.model).Count(tt.text); got != tt.want {
+			t.Errorf("NewEstimator(%q).Count(%q) = %d, want %d", tt.model, tt.text, got, tt.want)
+		}
+	}
+}
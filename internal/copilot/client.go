@@ -1,18 +1,32 @@
 package copilot
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"smart-testify/internal/logger"
+	"smart-testify/internal/provider"
+	"smart-testify/internal/sse"
 	"strings"
-	"time"
+	"sync"
 )
 
 const model = "gpt-4o"
 
+const (
+	editorVersion       = "vscode/1.80.1"
+	editorPluginVersion = "copilot-chat/0.12.0"
+	userAgent           = "smart-testify"
+)
+
+// ProviderName is the name the Copilot client is registered with
+const ProviderName = "copilot"
+
 var log = logger.GetLogger() // Global logger
 
 // Client struct holds the token and messages for interactions
@@ -20,130 +34,71 @@ type Client struct {
 	Token      string
 	Contextual bool // Whether to append the previous messages to the current request
 	Messages   []map[string]string
+	OnToken    provider.TokenHandler // Called with each chunk of the completion while it is streamed
+
+	tokens *TokenManager
+	mu     sync.Mutex
 }
 
-// NewCopilotClient initializes a Client instance
+// NewCopilotClient initializes a Client instance, token is the GitHub OAuth token
 func NewCopilotClient(token string, Contextual bool) *Client {
 	return &Client{
 		Contextual: Contextual,
 		Token:      token,
+		tokens:     NewTokenManager(token),
 	}
 }
 
-func GetCopilotToken() (string, error) {
-	clientID := "Iv1.b507a08c87ecfe98"
-	scope := "read:user"
-	url := "https://github.com/login/device/code"
-
-	reqBody := fmt.Sprintf(`{"client_id":"%s","scope":"%s"}`, clientID, scope)
-
-	// Create the initial request with Accept header
-	req, err := http.NewRequest("POST", url, strings.NewReader(reqBody))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Accept", "application/json")       // Add Accept header
-	req.Header.Set("Content-Type", "application/json") // Add Content-Type header
-
-	// Create a custom HTTP client with a longer timeout (e.g., 60 seconds)
-	client := &http.Client{
-		Timeout: 60 * time.Second, // Set timeout to 60 seconds
-	}
-	log.Infof("Requesting access token from GitHub Copilot...")
-	// Send the initial request
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read the entire response body
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error reading response body from github: %v", err)
-	}
+// Name returns the provider name of the client
+func (c *Client) Name() string {
+	return ProviderName
+}
 
-	var respJSON map[string]interface{}
-	if err := json.Unmarshal(respBody, &respJSON); err != nil {
-		return "", err
+// Capabilities returns the features supported by Copilot
+func (c *Client) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Streaming:  true,
+		Contextual: c.Contextual,
 	}
+}
 
-	// Extract the necessary fields from the response
-	deviceCode, ok := respJSON["device_code"].(string)
-	if !ok {
-		return "", errors.New("device_code not found in response")
-	}
-	userCode, ok := respJSON["user_code"].(string)
-	if !ok {
-		return "", errors.New("user_code not found in response")
+// Limits returns the token limits enforced by Copilot for the model in use
+func (c *Client) Limits() provider.Limits {
+	return provider.Limits{
+		Model:         model,
+		ContextTokens: 64000,
+		OutputTokens:  4096,
 	}
-	verificationURI, ok := respJSON["verification_uri"].(string)
-	if !ok {
-		return "", errors.New("verification_uri not found in response")
-	}
-
-	// Inform the user to authenticate
-	log.Infof("Please visit %s and enter code %s to authenticate.\n", verificationURI, userCode)
-
-	maxRetry := 10
-	// Wait for the user to authenticate
-	for maxRetry > 0 {
-		maxRetry--
-		time.Sleep(5 * time.Second)
-
-		// Request the access token
-		tokenURL := "https://github.com/login/oauth/access_token"
-		tokenReqBody := fmt.Sprintf(`{"client_id":"%s","device_code":"%s","grant_type":"urn:ietf:params:oauth:grant-type:device_code"}`, clientID, deviceCode)
-
-		// Create the token request with Accept header
-		req, err := http.NewRequest("POST", tokenURL, strings.NewReader(tokenReqBody))
-		if err != nil {
-			return "", err
-		}
-		req.Header.Set("Accept", "application/json")       // Add Accept header
-		req.Header.Set("Content-Type", "application/json") // Add Content-Type header
-
-		// Send the request for the access token
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return "", err
-		}
-		defer resp.Body.Close()
-
-		var respJSON map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&respJSON); err != nil {
-			return "", err
-		}
+}
 
-		// If we got the access token, save it and break out of the loop
-		accessToken, ok := respJSON["access_token"].(string)
-		if ok {
-			return accessToken, nil
-		}
-	}
+// GetCopilotToken runs the GitHub device flow and returns the OAuth access token
+func GetCopilotToken() (string, error) {
+	return NewDeviceFlow().Run()
+}
 
-	log.Errorf("Failed to get access token after %d retries", maxRetry)
-	return "", errors.New("failed to get access token")
+// SetTokenHandler sets the handler called with each chunk of the streamed completion
+func (c *Client) SetTokenHandler(handler provider.TokenHandler) {
+	c.OnToken = handler
 }
 
 // Chat sends a message to the Copilot API and returns the assistant's response
-func (c *Client) Chat(message string) (string, error) {
+func (c *Client) Chat(ctx context.Context, message string) (string, error) {
 	if c.Token == "" {
 		return "", errors.New("token is not initialized, please run 'smart-testify config copilot init-token' to initialize the token")
 	}
 
+	userMessage := map[string]string{
+		"content": message,
+		"role":    "user",
+	}
+	messages := []map[string]string{userMessage}
 	if c.Contextual {
-		c.Messages = append(c.Messages, map[string]string{
-			"content": message,
-			"role":    "user",
-		})
-	} else {
-		c.Messages = []map[string]string{
-			{
-				"content": message,
-				"role":    "user",
-			},
-		}
+		// A conversation is sequential, so concurrent calls are serialized to keep the messages in order
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.Messages = append(c.Messages, userMessage)
+		messages = c.Messages
 	}
 
 	chatURL := "https://api.githubcopilot.com/chat/completions"
@@ -154,7 +109,7 @@ func (c *Client) Chat(message string) (string, error) {
 		"top_p":       1,
 		"n":           1,
 		"stream":      true,
-		"messages":    c.Messages,
+		"messages":    messages,
 	}
 
 	reqBodyJSON, err := json.Marshal(reqBody)
@@ -162,68 +117,124 @@ func (c *Client) Chat(message string) (string, error) {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", chatURL, strings.NewReader(string(reqBodyJSON)))
+	resp, err := c.send(ctx, chatURL, reqBodyJSON)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Authorization", "Bearer "+c.Token)
-	req.Header.Set("Editor-Version", "vscode/1.80.1")
-	req.Header.Set("Content-Type", "application/json")
+	// Surface HTTP errors before trying to parse the body as an event stream
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return "", provider.NewHTTPError(resp, respBody)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	result, err := c.readStream(resp.Body)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	// Read the entire response body
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	// Append the assistant's response
+	if c.Contextual {
+		c.Messages = append(c.Messages, map[string]string{
+			"content": result,
+			"role":    "assistant",
+		})
 	}
 
-	// Convert the response body to a string and split by newlines
-	result := ""
-	respText := string(respBody)
-	lines := strings.Split(respText, "\n")
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "data: {") {
-			// Parse the completion from the line as JSON
-			var jsonCompletion map[string]interface{}
-			if err := json.Unmarshal([]byte(line[6:]), &jsonCompletion); err != nil {
-				continue // Skip invalid lines
-			}
+	return result, nil
+}
 
-			// Extract the "choices" array from the parsed JSON
-			choices, choicesExist := jsonCompletion["choices"].([]interface{})
-			if choicesExist && len(choices) > 0 {
-				choice := choices[0].(map[string]interface{})
-				if delta, deltaExist := choice["delta"].(map[string]interface{}); deltaExist {
-					if content, contentExist := delta["content"].(string); contentExist {
-						result += content
-					}
-				}
-			}
+// send posts the request with a session token, it retries once with a fresh token if the token was rejected
+func (c *Client) send(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		sessionToken, err := c.tokens.Token(ctx)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	// Append the assistant's response to messages
-	c.Messages = append(c.Messages, map[string]string{
-		"content": result,
-		"role":    "assistant",
-	})
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+sessionToken)
+		req.Header.Set("Editor-Version", editorVersion)
+		req.Header.Set("Editor-Plugin-Version", editorPluginVersion)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
 
-	if result == "" {
-		return "", fmt.Errorf("no response received, status code: %d, response body: %s", resp.StatusCode, respText)
+		// The session token may be revoked before it expires, refresh it and try again
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			c.tokens.Invalidate()
+			continue
+		}
+		return resp, nil
 	}
+}
 
-	// Append the assistant's response
-	c.Messages = append(c.Messages, map[string]string{
-		"content": result,
-		"role":    "assistant",
-	})
+// streamChunk is the payload of each event sent by the chat completions endpoint
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
 
-	return result, nil
+// readStream parses the event stream as it arrives and passes each chunk to OnToken
+func (c *Client) readStream(body io.Reader) (string, error) {
+	var result strings.Builder
+	reader := sse.NewReader(body)
+
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			log.Warnf("Event stream ended before [DONE] was received, the response may be truncated")
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read event stream: %v", err)
+		}
+
+		if event.Data == "[DONE]" {
+			break
+		}
+		if event.Name == "error" {
+			return "", fmt.Errorf("error event received: %s", event.Data)
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			return "", fmt.Errorf("failed to parse event %q: %v", event.Data, err)
+		}
+		if chunk.Error != nil {
+			return "", fmt.Errorf("error event received: %s (%s)", chunk.Error.Message, chunk.Error.Code)
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			result.WriteString(choice.Delta.Content)
+			if c.OnToken != nil {
+				c.OnToken(choice.Delta.Content)
+			}
+		}
+	}
+
+	if result.Len() == 0 {
+		return "", errors.New("no response received from the event stream")
+	}
+	return result.String(), nil
 }
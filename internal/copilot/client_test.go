@@ -0,0 +1,79 @@
+package copilot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientReadStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     string
+		want       string
+		wantTokens []string
+		wantErr    string
+	}{
+		{
+			name: "chunks until done",
+			stream: ": ping\n\n" +
+				"data: {\"choices\":[]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"func \"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"\"}},{\"delta\":{\"content\":\"TestX\"}}]}\n\n" +
+				"data: [DONE]\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"ignored\"}}]}\n\n",
+			want:       "func TestX",
+			wantTokens: []string{"func ", "TestX"},
+		},
+		{
+			name:       "stream ending without done",
+			stream:     "data: {\"choices\":[{\"delta\":{\"content\":\"func\"}}]}",
+			want:       "func",
+			wantTokens: []string{"func"},
+		},
+		{
+			name:    "error event",
+			stream:  "data: {\"choices\":[{\"delta\":{\"content\":\"func\"}}]}\n\nevent: error\ndata: rate limited\n\n",
+			wantErr: "error event received: rate limited",
+		},
+		{
+			name:    "error payload",
+			stream:  "data: {\"error\":{\"message\":\"too long\",\"code\":\"context_length_exceeded\"}}\n\n",
+			wantErr: "error event received: too long (context_length_exceeded)",
+		},
+		{
+			name:    "invalid event",
+			stream:  "data: {oops\n\n",
+			wantErr: "failed to parse event",
+		},
+		{
+			name:    "no content",
+			stream:  "data: [DONE]\n\n",
+			wantErr: "no response received from the event stream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tokens []string
+			client := &Client{}
+			client.SetTokenHandler(func(token string) { tokens = append(tokens, token) })
+
+			got, err := client.readStream(strings.NewReader(tt.stream))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readStream() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readStream() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("readStream() = %q, want %q", got, tt.want)
+			}
+			if strings.Join(tokens, "|") != strings.Join(tt.wantTokens, "|") {
+				t.Errorf("streamed tokens = %q, want %q", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package copilot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	githubClientID      = "Iv1.b507a08c87ecfe98"
+	githubScope         = "read:user"
+	githubDeviceCodeURL = "https://github.com/login/device/code"
+	githubTokenURL      = "https://github.com/login/oauth/access_token"
+
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// defaultPollInterval is used when the server sends no interval, see RFC 8628 section 3.2
+	defaultPollInterval = 5 * time.Second
+	// slowDownIncrease is added to the interval on every slow_down error, see RFC 8628 section 3.5
+	slowDownIncrease = 5 * time.Second
+)
+
+var (
+	// ErrAccessDenied is returned when the user declines the authorization request
+	ErrAccessDenied = errors.New("authorization was denied by the user")
+	// ErrExpiredToken is returned when the user did not authorize the device before the code expired
+	ErrExpiredToken = errors.New("the device code expired before the authorization was completed, please try again")
+)
+
+// DeviceCode is the response of the device authorization request
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// tokenResponse is the response of the token request, GitHub reports pending states as errors in the body
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	Interval         int    `json:"interval"`
+}
+
+// DeviceFlow implements the OAuth 2.0 device authorization grant (RFC 8628).
+// The endpoints, HTTP client and clock can be replaced to run it against a stub server.
+type DeviceFlow struct {
+	ClientID      string
+	Scope         string
+	DeviceCodeURL string
+	TokenURL      string
+	HTTPClient    *http.Client
+
+	Now   func() time.Time
+	Sleep func(time.Duration)
+
+	// Prompt tells the user where to enter the code
+	Prompt func(code *DeviceCode)
+}
+
+// NewDeviceFlow initializes a DeviceFlow for GitHub Copilot
+func NewDeviceFlow() *DeviceFlow {
+	return &DeviceFlow{
+		ClientID:      githubClientID,
+		Scope:         githubScope,
+		DeviceCodeURL: githubDeviceCodeURL,
+		TokenURL:      githubTokenURL,
+		HTTPClient:    &http.Client{Timeout: 60 * time.Second},
+		Now:           time.Now,
+		Sleep:         time.Sleep,
+		Prompt: func(code *DeviceCode) {
+			log.Infof("Please visit %s and enter code %s to authenticate.", code.VerificationURI, code.UserCode)
+		},
+	}
+}
+
+// Run requests a device code, asks the user to authorize it and waits for the access token
+func (f *DeviceFlow) Run() (string, error) {
+	log.Infof("Requesting device code from GitHub...")
+	code, err := f.RequestCode()
+	if err != nil {
+		return "", err
+	}
+
+	f.Prompt(code)
+	return f.PollToken(code)
+}
+
+// RequestCode starts the flow and returns the code the user has to enter
+func (f *DeviceFlow) RequestCode() (*DeviceCode, error) {
+	body, status, err := f.post(f.DeviceCodeURL, url.Values{
+		"client_id": {f.ClientID},
+		"scope":     {f.Scope},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("device code request failed with status %d, body: %s", status, string(body))
+	}
+
+	var code DeviceCode
+	if err := json.Unmarshal(body, &code); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device code response: %v", err)
+	}
+	if code.DeviceCode == "" {
+		return nil, errors.New("device_code not found in response")
+	}
+	if code.UserCode == "" {
+		return nil, errors.New("user_code not found in response")
+	}
+	if code.VerificationURI == "" {
+		return nil, errors.New("verification_uri not found in response")
+	}
+	return &code, nil
+}
+
+// PollToken polls the token endpoint at the interval requested by the server until the user
+// authorizes the device, declines it, or the code expires
+func (f *DeviceFlow) PollToken(code *DeviceCode) (string, error) {
+	interval := defaultPollInterval
+	if code.Interval > 0 {
+		interval = time.Duration(code.Interval) * time.Second
+	}
+
+	var deadline time.Time
+	if code.ExpiresIn > 0 {
+		deadline = f.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !deadline.IsZero() && !f.Now().Add(interval).Before(deadline) {
+			return "", ErrExpiredToken
+		}
+		f.Sleep(interval)
+
+		body, status, err := f.post(f.TokenURL, url.Values{
+			"client_id":   {f.ClientID},
+			"device_code": {code.DeviceCode},
+			"grant_type":  {deviceCodeGrantType},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		var resp tokenResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return "", fmt.Errorf("failed to unmarshal token response with status %d: %v, body: %s", status, err, string(body))
+		}
+
+		if resp.AccessToken != "" {
+			log.Debugf("Access token received after %d polls", attempt)
+			return resp.AccessToken, nil
+		}
+
+		switch resp.Error {
+		case "authorization_pending":
+			log.Debugf("Waiting for the user to authorize the device, poll %d", attempt)
+		case "slow_down":
+			// The server may send the new interval, otherwise increase it by 5 seconds
+			if resp.Interval > 0 {
+				interval = time.Duration(resp.Interval) * time.Second
+			} else {
+				interval += slowDownIncrease
+			}
+			log.Debugf("Asked to slow down, polling every %v", interval)
+		case "access_denied":
+			return "", ErrAccessDenied
+		case "expired_token":
+			return "", ErrExpiredToken
+		case "":
+			return "", fmt.Errorf("access token not found in response with status %d, body: %s", status, string(body))
+		default:
+			return "", fmt.Errorf("failed to get access token: %s %s", resp.Error, resp.ErrorDescription)
+		}
+	}
+}
+
+// post sends the form to the endpoint and returns the response body and status code
+func (f *DeviceFlow) post(endpoint string, form url.Values) ([]byte, int, error) {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request to %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read response body from %s: %v", endpoint, err)
+	}
+	return body, resp.StatusCode, nil
+}
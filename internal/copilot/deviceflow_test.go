@@ -0,0 +1,184 @@
+package copilot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubFlow returns a DeviceFlow against a stub server answering the token requests with the responses
+// in order, and a fake clock which advances on each sleep
+func stubFlow(t *testing.T, responses []string) (*DeviceFlow, *[]time.Duration, *int) {
+	t.Helper()
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/device/code":
+			w.Write([]byte(`{"device_code":"dev","user_code":"ABCD-1234","verification_uri":"https://example.com/device","expires_in":900,"interval":5}`))
+		case "/token":
+			if err := r.ParseForm(); err != nil {
+				t.Errorf("failed to parse the token request: %v", err)
+			}
+			if r.Form.Get("device_code") != "dev" || r.Form.Get("grant_type") != deviceCodeGrantType || r.Form.Get("client_id") != "client" {
+				t.Errorf("unexpected token request form: %v", r.Form)
+			}
+			if polls >= len(responses) {
+				t.Errorf("unexpected poll %d", polls+1)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(responses[polls]))
+			polls++
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var sleeps []time.Duration
+	flow := &DeviceFlow{
+		ClientID:      "client",
+		Scope:         githubScope,
+		DeviceCodeURL: server.URL + "/device/code",
+		TokenURL:      server.URL + "/token",
+		HTTPClient:    server.Client(),
+		Now:           func() time.Time { return now },
+		Sleep: func(d time.Duration) {
+			sleeps = append(sleeps, d)
+			now = now.Add(d)
+		},
+		Prompt: func(*DeviceCode) {},
+	}
+	return flow, &sleeps, &polls
+}
+
+func TestDeviceFlowPollToken(t *testing.T) {
+	pending := `{"error":"authorization_pending"}`
+	success := `{"access_token":"gho_token","token_type":"bearer"}`
+
+	tests := []struct {
+		name       string
+		code       DeviceCode
+		responses  []string
+		want       string
+		wantErr    error
+		wantErrMsg string
+		wantSleeps []time.Duration
+	}{
+		{
+			name:       "success after authorization_pending",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5, ExpiresIn: 900},
+			responses:  []string{pending, pending, success},
+			want:       "gho_token",
+			wantSleeps: []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:       "default interval",
+			code:       DeviceCode{DeviceCode: "dev"},
+			responses:  []string{success},
+			want:       "gho_token",
+			wantSleeps: []time.Duration{defaultPollInterval},
+		},
+		{
+			name:       "slow_down bumps the interval by 5 seconds",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5, ExpiresIn: 900},
+			responses:  []string{`{"error":"slow_down"}`, pending, `{"error":"slow_down"}`, success},
+			want:       "gho_token",
+			wantSleeps: []time.Duration{5 * time.Second, 10 * time.Second, 10 * time.Second, 15 * time.Second},
+		},
+		{
+			name:       "slow_down with the new interval",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5, ExpiresIn: 900},
+			responses:  []string{`{"error":"slow_down","interval":30}`, success},
+			want:       "gho_token",
+			wantSleeps: []time.Duration{5 * time.Second, 30 * time.Second},
+		},
+		{
+			name:       "expired_token",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5, ExpiresIn: 900},
+			responses:  []string{pending, `{"error":"expired_token"}`},
+			wantErr:    ErrExpiredToken,
+			wantSleeps: []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:       "the code expires before the next poll",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5, ExpiresIn: 12},
+			responses:  []string{pending, pending},
+			wantErr:    ErrExpiredToken,
+			wantSleeps: []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:       "access_denied",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5},
+			responses:  []string{pending, `{"error":"access_denied"}`},
+			wantErr:    ErrAccessDenied,
+			wantSleeps: []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:       "other error",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5},
+			responses:  []string{`{"error":"unsupported_grant_type","error_description":"bad grant"}`},
+			wantErrMsg: "unsupported_grant_type bad grant",
+			wantSleeps: []time.Duration{5 * time.Second},
+		},
+		{
+			name:       "no token and no error",
+			code:       DeviceCode{DeviceCode: "dev", Interval: 5},
+			responses:  []string{`{}`},
+			wantErrMsg: "access token not found",
+			wantSleeps: []time.Duration{5 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, sleeps, polls := stubFlow(t, tt.responses)
+			got, err := flow.PollToken(&tt.code)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("PollToken() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantErrMsg != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+					t.Fatalf("PollToken() error = %v, want it to contain %q", err, tt.wantErrMsg)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("PollToken() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("PollToken() = %q, want %q", got, tt.want)
+				}
+			}
+			if !reflect.DeepEqual(*sleeps, tt.wantSleeps) {
+				t.Errorf("PollToken() slept %v, want %v", *sleeps, tt.wantSleeps)
+			}
+			if *polls != len(tt.responses) {
+				t.Errorf("PollToken() polled %d times, want %d", *polls, len(tt.responses))
+			}
+		})
+	}
+}
+
+func TestDeviceFlowRun(t *testing.T) {
+	flow, _, _ := stubFlow(t, []string{`{"access_token":"gho_token"}`})
+	var prompted *DeviceCode
+	flow.Prompt = func(code *DeviceCode) { prompted = code }
+
+	got, err := flow.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got != "gho_token" {
+		t.Errorf("Run() = %q, want gho_token", got)
+	}
+	if prompted == nil || prompted.UserCode != "ABCD-1234" || prompted.VerificationURI != "https://example.com/device" {
+		t.Errorf("Run() prompted with %+v, want the user code and verification URI", prompted)
+	}
+}
@@ -0,0 +1,126 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionTokenURL exchanges the GitHub OAuth token for a short-lived Copilot session token
+const sessionTokenURL = "https://api.github.com/copilot_internal/v2/token"
+
+// refreshMargin is how long before its expiry a session token is refreshed
+const refreshMargin = 2 * time.Minute
+
+// ErrReauthRequired is returned when GitHub rejects the OAuth token, e.g. because it was revoked
+var ErrReauthRequired = errors.New("the GitHub OAuth token is invalid or has been revoked, please run 'smart-testify config copilot init-token' to authenticate again")
+
+// sessionTokenResponse is returned by the token exchange endpoint
+type sessionTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+	RefreshIn int64  `json:"refresh_in"`
+}
+
+// TokenManager exchanges the OAuth token for session tokens and caches them until they expire
+type TokenManager struct {
+	OAuthToken string
+	URL        string
+	HTTPClient *http.Client
+	Now        func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewTokenManager initializes a TokenManager for the OAuth token obtained by GetCopilotToken
+func NewTokenManager(oauthToken string) *TokenManager {
+	return &TokenManager{
+		OAuthToken: oauthToken,
+		URL:        sessionTokenURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Now:        time.Now,
+	}
+}
+
+// Token returns a valid session token, it is refreshed transparently when it is about to expire.
+// The refresh is cancelled with the context.
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && m.Now().Add(refreshMargin).Before(m.expiresAt) {
+		return m.token, nil
+	}
+
+	log.Debugf("Refreshing Copilot session token")
+	resp, err := m.exchange(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	m.token = resp.Token
+	m.expiresAt = time.Unix(resp.ExpiresAt, 0)
+	if resp.RefreshIn > 0 {
+		// Prefer the refresh hint of the server, it is earlier than the expiry
+		refreshAt := m.Now().Add(time.Duration(resp.RefreshIn)*time.Second + refreshMargin)
+		if refreshAt.Before(m.expiresAt) {
+			m.expiresAt = refreshAt
+		}
+	}
+	return m.token, nil
+}
+
+// Invalidate drops the cached session token, e.g. after the chat endpoint rejected it
+func (m *TokenManager) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.token = ""
+	m.expiresAt = time.Time{}
+}
+
+func (m *TokenManager) exchange(ctx context.Context) (*sessionTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", m.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+m.OAuthToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Editor-Version", editorVersion)
+	req.Header.Set("Editor-Plugin-Version", editorPluginVersion)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := m.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request Copilot session token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session token response: %v", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w (status: %s, body: %s)", ErrReauthRequired, resp.Status, string(body))
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to get Copilot session token, status: %s, body: %s", resp.Status, string(body))
+	}
+
+	var tokenResp sessionTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session token response: %v", err)
+	}
+	if tokenResp.Token == "" {
+		return nil, fmt.Errorf("session token not found in response: %s", string(body))
+	}
+	return &tokenResp, nil
+}
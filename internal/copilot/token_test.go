@@ -0,0 +1,198 @@
+package copilot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exchangeResponse is a response of the stub token exchange endpoint
+type exchangeResponse struct {
+	status int
+	body   string
+}
+
+// stubTokenManager returns a TokenManager against a stub server answering the exchanges with the
+// responses in order, and a fake clock set to start
+func stubTokenManager(t *testing.T, start time.Time, responses []exchangeResponse) (*TokenManager, *time.Time, *int) {
+	t.Helper()
+	exchanges := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Authorization") != "token oauth" {
+			t.Errorf("unexpected token request %s with Authorization %q", r.Method, r.Header.Get("Authorization"))
+		}
+		if exchanges >= len(responses) {
+			t.Errorf("unexpected exchange %d", exchanges+1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(responses[exchanges].status)
+		w.Write([]byte(responses[exchanges].body))
+		exchanges++
+	}))
+	t.Cleanup(server.Close)
+
+	now := start
+	manager := NewTokenManager("oauth")
+	manager.URL = server.URL
+	manager.HTTPClient = server.Client()
+	manager.Now = func() time.Time { return now }
+	return manager, &now, &exchanges
+}
+
+// sessionToken returns the body of a successful exchange
+func sessionToken(token string, expiresAt time.Time, refreshIn int) exchangeResponse {
+	return exchangeResponse{
+		status: http.StatusOK,
+		body:   fmt.Sprintf(`{"token":%q,"expires_at":%d,"refresh_in":%d}`, token, expiresAt.Unix(), refreshIn),
+	}
+}
+
+func TestTokenManagerRefresh(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := start.Add(30 * time.Minute)
+
+	tests := []struct {
+		name      string
+		refreshIn int
+		cachedAt  time.Duration // Last offset from start at which the first token is still used
+		refreshAt time.Duration // Offset from start at which the token is refreshed
+	}{
+		{
+			name:      "refreshed before expires_at",
+			cachedAt:  30*time.Minute - refreshMargin - time.Second,
+			refreshAt: 30*time.Minute - refreshMargin,
+		},
+		{
+			name:      "refresh_in earlier than expires_at",
+			refreshIn: 1500,
+			cachedAt:  1499 * time.Second,
+			refreshAt: 1500 * time.Second,
+		},
+		{
+			name:      "refresh_in later than expires_at",
+			refreshIn: 3600,
+			cachedAt:  30*time.Minute - refreshMargin - time.Second,
+			refreshAt: 30*time.Minute - refreshMargin,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, now, exchanges := stubTokenManager(t, start, []exchangeResponse{
+				sessionToken("first", expiresAt, tt.refreshIn),
+				sessionToken("second", expiresAt.Add(time.Hour), 0),
+			})
+			ctx := context.Background()
+
+			for _, step := range []struct {
+				at   time.Duration
+				want string
+			}{
+				{at: 0, want: "first"},
+				{at: tt.cachedAt, want: "first"},
+				{at: tt.refreshAt, want: "second"},
+			} {
+				*now = start.Add(step.at)
+				got, err := manager.Token(ctx)
+				if err != nil {
+					t.Fatalf("Token() at %v error = %v", step.at, err)
+				}
+				if got != step.want {
+					t.Errorf("Token() at %v = %q, want %q", step.at, got, step.want)
+				}
+			}
+			if *exchanges != 2 {
+				t.Errorf("Token() exchanged %d times, want 2", *exchanges)
+			}
+		})
+	}
+}
+
+func TestTokenManagerInvalidate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager, _, exchanges := stubTokenManager(t, start, []exchangeResponse{
+		sessionToken("first", start.Add(time.Hour), 0),
+		sessionToken("second", start.Add(time.Hour), 0),
+	})
+
+	if got, err := manager.Token(context.Background()); err != nil || got != "first" {
+		t.Fatalf("Token() = %q, %v, want first", got, err)
+	}
+	manager.Invalidate()
+	if got, err := manager.Token(context.Background()); err != nil || got != "second" {
+		t.Fatalf("Token() after Invalidate() = %q, %v, want second", got, err)
+	}
+	if *exchanges != 2 {
+		t.Errorf("Token() exchanged %d times, want 2", *exchanges)
+	}
+}
+
+func TestTokenManagerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   exchangeResponse
+		wantErr    error
+		wantErrMsg string
+	}{
+		{
+			name:     "unauthorized",
+			response: exchangeResponse{status: http.StatusUnauthorized, body: `{"message":"Bad credentials"}`},
+			wantErr:  ErrReauthRequired,
+		},
+		{
+			name:     "forbidden",
+			response: exchangeResponse{status: http.StatusForbidden, body: `{"message":"no Copilot seat"}`},
+			wantErr:  ErrReauthRequired,
+		},
+		{
+			name:       "server error",
+			response:   exchangeResponse{status: http.StatusInternalServerError, body: `boom`},
+			wantErrMsg: "failed to get Copilot session token, status: 500",
+		},
+		{
+			name:       "invalid body",
+			response:   exchangeResponse{status: http.StatusOK, body: `oops`},
+			wantErrMsg: "failed to unmarshal session token response",
+		},
+		{
+			name:       "no token",
+			response:   exchangeResponse{status: http.StatusOK, body: `{"expires_at":1}`},
+			wantErrMsg: "session token not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, _, _ := stubTokenManager(t, time.Now(), []exchangeResponse{tt.response})
+			_, err := manager.Token(context.Background())
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Token() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErrMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErrMsg)) {
+				t.Fatalf("Token() error = %v, want it to contain %q", err, tt.wantErrMsg)
+			}
+			if errors.Is(err, ErrReauthRequired) != (tt.wantErr == ErrReauthRequired) {
+				t.Errorf("Token() error = %v, ErrReauthRequired only on 401 and 403", err)
+			}
+		})
+	}
+}
+
+func TestTokenManagerCancelled(t *testing.T) {
+	manager, _, exchanges := stubTokenManager(t, time.Now(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := manager.Token(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Token() with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+	if *exchanges != 0 {
+		t.Errorf("Token() reached the server %d times with a cancelled context", *exchanges)
+	}
+}
@@ -0,0 +1,151 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"strings"
+)
+
+// Func is the coverage of a function
+type Func struct {
+	Blocks    []Block // Blocks in the body of the function
+	Uncovered []Block // Blocks which are not executed by any test
+}
+
+// ForFunc returns the coverage of the function, from the blocks of its file
+func ForFunc(fset *token.FileSet, fn *ast.FuncDecl, blocks []Block) Func {
+	var f Func
+	if fn.Body == nil {
+		return f
+	}
+
+	start := fset.Position(fn.Body.Lbrace)
+	end := fset.Position(fn.Body.Rbrace)
+	for _, block := range blocks {
+		if before(block.StartLine, block.StartCol, start.Line, start.Column) ||
+			before(end.Line, end.Column+1, block.EndLine, block.EndCol) {
+			continue
+		}
+		f.Blocks = append(f.Blocks, block)
+		if block.Count == 0 {
+			f.Uncovered = append(f.Uncovered, block)
+		}
+	}
+	return f
+}
+
+// Percent returns the percentage of covered statements of the function
+func (f Func) Percent() float64 {
+	return Percent(f.Blocks)
+}
+
+// Describe returns the lines of the block and the branch leading to it, if any,
+// e.g. "lines 12-14, when err != nil"
+func Describe(fset *token.FileSet, fn *ast.FuncDecl, block Block) string {
+	// A block ending at the first column doesn't include anything of its last line
+	endLine := block.EndLine
+	if endLine > block.StartLine && block.EndCol <= 1 {
+		endLine--
+	}
+
+	lines := fmt.Sprintf("line %d", block.StartLine)
+	if endLine > block.StartLine {
+		lines = fmt.Sprintf("lines %d-%d", block.StartLine, endLine)
+	}
+
+	if branch := findBranch(fset, fn, block); branch != "" {
+		return lines + ", " + branch
+	}
+	return lines
+}
+
+// findBranch finds the statement whose body starts where the block starts and describes its condition
+func findBranch(fset *token.FileSet, fn *ast.FuncDecl, block Block) string {
+	file := fset.File(fn.Pos())
+	if file == nil || fn.Body == nil || block.StartLine < 1 || block.StartLine > file.LineCount() {
+		return ""
+	}
+	pos := file.LineStart(block.StartLine) + token.Pos(block.StartCol-1)
+
+	// Depending on the Go version, blocks start at the opening brace of a body or after the colon
+	// of a case, or at the first statement of the body
+	startsAt := func(p token.Pos, body []ast.Stmt) bool {
+		if len(body) > 0 && pos == body[0].Pos() {
+			return true
+		}
+		return p.IsValid() && (pos == p || pos == p+1)
+	}
+
+	var branch string
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if branch != "" {
+			return false
+		}
+		switch x := n.(type) {
+		case *ast.IfStmt:
+			if startsAt(x.Body.Lbrace, x.Body.List) {
+				branch = "when " + nodeString(fset, x.Cond)
+			} else if elseBlock, ok := x.Else.(*ast.BlockStmt); ok && startsAt(elseBlock.Lbrace, elseBlock.List) {
+				branch = "when !(" + nodeString(fset, x.Cond) + ")"
+			}
+		case *ast.CaseClause:
+			if startsAt(x.Colon, x.Body) {
+				if len(x.List) == 0 {
+					branch = "in the default case"
+				} else {
+					branch = "in case " + exprList(fset, x.List)
+				}
+			}
+		case *ast.CommClause:
+			if startsAt(x.Colon, x.Body) {
+				if x.Comm == nil {
+					branch = "in the default case of the select"
+				} else {
+					branch = "in case " + nodeString(fset, x.Comm)
+				}
+			}
+		case *ast.ForStmt:
+			if startsAt(x.Body.Lbrace, x.Body.List) {
+				if x.Cond != nil {
+					branch = "in the loop while " + nodeString(fset, x.Cond)
+				} else {
+					branch = "in the loop body"
+				}
+			}
+		case *ast.RangeStmt:
+			if startsAt(x.Body.Lbrace, x.Body.List) {
+				branch = "in the loop over " + nodeString(fset, x.X)
+			}
+		case *ast.FuncLit:
+			if startsAt(x.Body.Lbrace, x.Body.List) {
+				branch = "in the function literal"
+			}
+		}
+		return true
+	})
+	return branch
+}
+
+func exprList(fset *token.FileSet, list []ast.Expr) string {
+	parts := make([]string, len(list))
+	for i, expr := range list {
+		parts[i] = nodeString(fset, expr)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func nodeString(fset *token.FileSet, node ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// before reports whether the position line1.col1 is before line2.col2
+func before(line1, col1, line2, col2 int) bool {
+	return line1 < line2 || (line1 == line2 && col1 < col2)
+}
@@ -0,0 +1,129 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Block is a block of statements of a cover profile, see go tool cover
+type Block struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int
+}
+
+// Profile holds the blocks of a cover profile, keyed by the file name found in the profile,
+// which is the import path of the package followed by the base name of the file
+type Profile struct {
+	Mode   string
+	Blocks map[string][]Block
+}
+
+// ParseProfile parses a profile written by go test -coverprofile. The blocks found several times,
+// e.g. when the package is covered by several test binaries, are merged.
+func ParseProfile(r io.Reader) (*Profile, error) {
+	profile := &Profile{Blocks: make(map[string][]Block)}
+	seen := make(map[string]map[[4]int]int)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode: ") {
+			profile.Mode = strings.TrimPrefix(line, "mode: ")
+			continue
+		}
+
+		// Format: name.go:line.column,line.column numberOfStatements count
+		colon := strings.LastIndex(line, ":")
+		if colon == -1 {
+			return nil, fmt.Errorf("invalid profile line %d: %s", lineNum, line)
+		}
+		fileName := line[:colon]
+
+		var block Block
+		if _, err := fmt.Sscanf(line[colon+1:], "%d.%d,%d.%d %d %d",
+			&block.StartLine, &block.StartCol, &block.EndLine, &block.EndCol, &block.NumStmt, &block.Count); err != nil {
+			return nil, fmt.Errorf("invalid profile line %d: %s: %v", lineNum, line, err)
+		}
+
+		if seen[fileName] == nil {
+			seen[fileName] = make(map[[4]int]int)
+		}
+		key := [4]int{block.StartLine, block.StartCol, block.EndLine, block.EndCol}
+		if i, ok := seen[fileName][key]; ok {
+			if block.Count > profile.Blocks[fileName][i].Count {
+				profile.Blocks[fileName][i].Count = block.Count
+			}
+			continue
+		}
+		seen[fileName][key] = len(profile.Blocks[fileName])
+		profile.Blocks[fileName] = append(profile.Blocks[fileName], block)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read profile: %v", err)
+	}
+
+	for _, blocks := range profile.Blocks {
+		sort.Slice(blocks, func(i, j int) bool {
+			if blocks[i].StartLine != blocks[j].StartLine {
+				return blocks[i].StartLine < blocks[j].StartLine
+			}
+			return blocks[i].StartCol < blocks[j].StartCol
+		})
+	}
+	return profile, nil
+}
+
+// ParseProfileFile parses the profile at the given path
+func ParseProfileFile(profilePath string) (*Profile, error) {
+	file, err := os.Open(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseProfile(file)
+}
+
+// FileBlocks returns the blocks of the source file. The profile is expected to cover a single
+// package, so the file is matched by its base name. It returns nil if the file is not in the profile.
+func (p *Profile) FileBlocks(filePath string) []Block {
+	base := filepath.Base(filePath)
+	for fileName, blocks := range p.Blocks {
+		if path.Base(fileName) == base {
+			return blocks
+		}
+	}
+	return nil
+}
+
+// Percent returns the percentage of covered statements of the blocks. The blocks are missing when
+// the file is not measured, which is 0%, while blocks without statements have nothing to cover.
+func Percent(blocks []Block) float64 {
+	if len(blocks) == 0 {
+		return 0
+	}
+
+	var total, covered int
+	for _, block := range blocks {
+		total += block.NumStmt
+		if block.Count > 0 {
+			covered += block.NumStmt
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return float64(covered) * 100 / float64(total)
+}
@@ -0,0 +1,105 @@
+package coverage
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []Block
+		want   float64
+	}{
+		{name: "no blocks", want: 0},
+		{name: "uncovered", blocks: []Block{{NumStmt: 2}, {NumStmt: 2}}, want: 0},
+		{name: "partly covered", blocks: []Block{{NumStmt: 1, Count: 3}, {NumStmt: 3}}, want: 25},
+		{name: "covered", blocks: []Block{{NumStmt: 2, Count: 1}}, want: 100},
+		{name: "empty function not run", blocks: []Block{{NumStmt: 0}}, want: 100},
+		{name: "empty function run", blocks: []Block{{NumStmt: 0, Count: 1}}, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Percent(tt.blocks); got != tt.want {
+				t.Errorf("Percent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileBlocks(t *testing.T) {
+	profile, err := ParseProfile(strings.NewReader(`mode: set
+example.com/p/a.go:3.20,5.2 1 1
+example.com/p/a.go:5.2,7.3 2 0
+`))
+	if err != nil {
+		t.Fatalf("ParseProfile() error = %v", err)
+	}
+
+	if got := profile.FileBlocks("/src/p/a.go"); len(got) != 2 {
+		t.Errorf("FileBlocks() of a.go = %v, want 2 blocks", got)
+	}
+	missing := profile.FileBlocks("/src/p/b.go")
+	if missing != nil {
+		t.Errorf("FileBlocks() of a file missing from the profile = %v, want nil", missing)
+	}
+	if got := Percent(missing); got != 0 {
+		t.Errorf("Percent() of a file missing from the profile = %v, want 0", got)
+	}
+}
+
+func TestForFunc(t *testing.T) {
+	const source = `package calc
+
+// Add adds
+func Add(a, b int) int { return a + b }
+
+// Noop does nothing
+func Noop() {}
+
+func Abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+`
+	profile, err := ParseProfile(strings.NewReader(`mode: set
+example.com/calc/calc.go:4.24,4.38 1 0
+example.com/calc/calc.go:7.14,7.14 0 0
+example.com/calc/calc.go:9.21,10.11 1 1
+example.com/calc/calc.go:10.11,12.3 1 0
+example.com/calc/calc.go:13.2,13.10 1 1
+`))
+	if err != nil {
+		t.Fatalf("ParseProfile() error = %v", err)
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "calc.go", source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct {
+		blocks, uncovered int
+		percent           float64
+	}{
+		"Add":  {blocks: 1, uncovered: 1, percent: 0},
+		"Noop": {blocks: 1, uncovered: 1, percent: 100},
+		"Abs":  {blocks: 3, uncovered: 1, percent: 200.0 / 3},
+	}
+	blocks := profile.FileBlocks("calc.go")
+	for _, decl := range file.Decls {
+		fn := decl.(*ast.FuncDecl)
+		got := ForFunc(fset, fn, blocks)
+		w := want[fn.Name.Name]
+		if len(got.Blocks) != w.blocks || len(got.Uncovered) != w.uncovered || got.Percent() != w.percent {
+			t.Errorf("ForFunc(%s) = %d blocks, %d uncovered, %v%%, want %d, %d, %v%%",
+				fn.Name.Name, len(got.Blocks), len(got.Uncovered), got.Percent(), w.blocks, w.uncovered, w.percent)
+		}
+	}
+}
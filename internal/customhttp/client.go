@@ -0,0 +1,255 @@
+package customhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"smart-testify/internal/logger"
+	"smart-testify/internal/provider"
+	"smart-testify/internal/sse"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var log = logger.GetLogger() // Global logger
+
+// ProviderName is the name the custom HTTP client is registered with
+const ProviderName = "custom"
+
+// defaultTimeout is used when no timeout is configured
+const defaultTimeout = 120 * time.Second
+
+// Config describes how to talk to an HTTP gateway, entirely without Go code.
+//
+// BodyTemplate and the Headers values are Go text/templates. The body is rendered with
+// .Prompt and .Model already escaped for a JSON string, so `{"input": "{{.Prompt}}"}` is valid JSON.
+// The env function reads an environment variable, e.g. `Bearer {{env "GATEWAY_TOKEN"}}`.
+type Config struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method"`
+	Model          string            `json:"model"`
+	Headers        map[string]string `json:"headers"`
+	BodyTemplate   string            `json:"body_template"`
+	ResponsePath   string            `json:"response_path"` // Path of the completion in the response, e.g. data.choices[0].text
+	ErrorPath      string            `json:"error_path"`    // Optional path of an error message in the response or event
+	Stream         bool              `json:"stream"`        // Whether the response is sent as server-sent events
+	EventPath      string            `json:"event_path"`    // Path of the completion chunk in each event
+	DoneData       string            `json:"done_data"`     // Event data that ends the stream, e.g. [DONE]
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	ContextTokens  int               `json:"context_tokens"`
+}
+
+// templateData is passed to the body template
+type templateData struct {
+	Prompt string
+	Model  string
+}
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// Client sends prompts to a gateway described by Config
+type Client struct {
+	Config     Config
+	HTTPClient *http.Client
+	OnToken    provider.TokenHandler // Called with each chunk of the completion while it is streamed
+
+	body    *template.Template
+	headers map[string]*template.Template
+}
+
+// NewClient validates the config and parses its templates
+func NewClient(config Config) (*Client, error) {
+	if config.URL == "" {
+		return nil, errors.New("custom provider url is not configured, please run 'smart-testify config custom set --url <url>' to set it")
+	}
+	if config.BodyTemplate == "" {
+		return nil, errors.New("custom provider body template is not configured, please run 'smart-testify config custom set --body-template <template>' to set it")
+	}
+	if config.Stream && config.EventPath == "" {
+		return nil, errors.New("custom provider event path is required when stream is enabled")
+	}
+	if !config.Stream && config.ResponsePath == "" {
+		return nil, errors.New("custom provider response path is not configured, please run 'smart-testify config custom set --response-path <path>' to set it")
+	}
+
+	body, err := template.New("body").Funcs(templateFuncs).Parse(config.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body template: %v", err)
+	}
+
+	headers := make(map[string]*template.Template, len(config.Headers))
+	for name, value := range config.Headers {
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template of header %s: %v", name, err)
+		}
+		headers[name] = tmpl
+	}
+
+	timeout := defaultTimeout
+	if config.TimeoutSeconds > 0 {
+		timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+
+	return &Client{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: timeout},
+		body:       body,
+		headers:    headers,
+	}, nil
+}
+
+// Name returns the provider name of the client
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// Capabilities returns the features supported by the gateway
+func (c *Client) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Streaming: c.Config.Stream,
+	}
+}
+
+// Limits returns the token limits configured for the gateway
+func (c *Client) Limits() provider.Limits {
+	return provider.Limits{
+		Model:         c.Config.Model,
+		ContextTokens: c.Config.ContextTokens,
+	}
+}
+
+// SetTokenHandler sets the handler called with each chunk of the streamed completion
+func (c *Client) SetTokenHandler(handler provider.TokenHandler) {
+	c.OnToken = handler
+}
+
+// Chat renders the request from the templates, sends it and extracts the completion
+func (c *Client) Chat(ctx context.Context, prompt string) (string, error) {
+	var body bytes.Buffer
+	if err := c.body.Execute(&body, templateData{
+		Prompt: jsonEscape(prompt),
+		Model:  jsonEscape(c.Config.Model),
+	}); err != nil {
+		return "", fmt.Errorf("failed to render body template: %v", err)
+	}
+
+	method := c.Config.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.Config.URL, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, tmpl := range c.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, templateData{Model: c.Config.Model}); err != nil {
+			return "", fmt.Errorf("failed to render template of header %s: %v", name, err)
+		}
+		req.Header.Set(name, value.String())
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return "", provider.NewHTTPError(resp, respBody)
+	}
+
+	if c.Config.Stream {
+		return c.readStream(resp.Body)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %v", err)
+	}
+	if err := c.checkError(respBody); err != nil {
+		return "", err
+	}
+	completion, err := extractPath(respBody, c.Config.ResponsePath)
+	if err != nil {
+		return "", fmt.Errorf("%v, response body: %s", err, string(respBody))
+	}
+	if completion == "" {
+		return "", fmt.Errorf("no response received, response body: %s", string(respBody))
+	}
+	return completion, nil
+}
+
+// readStream concatenates the chunks found at the event path of each server-sent event. The events
+// without the path are skipped, it fails with the last path error if no event had it.
+func (c *Client) readStream(body io.Reader) (string, error) {
+	var result strings.Builder
+	var pathErr error
+	reader := sse.NewReader(body)
+
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read event stream: %v", err)
+		}
+		if c.Config.DoneData != "" && event.Data == c.Config.DoneData {
+			break
+		}
+		if err := c.checkError([]byte(event.Data)); err != nil {
+			return "", err
+		}
+
+		// Events without the path, e.g. role or usage chunks, carry no completion
+		chunk, err := extractPath([]byte(event.Data), c.Config.EventPath)
+		if err != nil {
+			log.Debugf("Skipping event without a completion chunk: %v, event: %s", err, event.Data)
+			pathErr = err
+			continue
+		}
+		result.WriteString(chunk)
+		if c.OnToken != nil && chunk != "" {
+			c.OnToken(chunk)
+		}
+	}
+
+	if result.Len() == 0 {
+		if pathErr != nil {
+			return "", fmt.Errorf("no response received from the event stream: %v", pathErr)
+		}
+		return "", errors.New("no response received from the event stream")
+	}
+	return result.String(), nil
+}
+
+// checkError returns the message found at the error path, if any
+func (c *Client) checkError(data []byte) error {
+	if c.Config.ErrorPath == "" {
+		return nil
+	}
+	message, err := extractPath(data, c.Config.ErrorPath)
+	if err != nil || message == "" {
+		return nil
+	}
+	return fmt.Errorf("request failed: %s", message)
+}
+
+// jsonEscape escapes s so it can be placed between double quotes in a JSON document
+func jsonEscape(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded[1 : len(encoded)-1])
+}
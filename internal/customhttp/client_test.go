@@ -0,0 +1,178 @@
+package customhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"smart-testify/internal/provider"
+	"strings"
+	"testing"
+)
+
+func TestClientChat(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		status     int
+		response   string
+		want       string
+		wantTokens []string
+		wantErr    string
+		wantCode   int // Status of the HTTPError expected, 0 if none
+	}{
+		{
+			name:     "completion",
+			config:   Config{ResponsePath: "output.text"},
+			status:   http.StatusOK,
+			response: `{"output":{"text":"func TestX(t *testing.T) {}"}}`,
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "wrong response path",
+			config:   Config{ResponsePath: "output.content"},
+			status:   http.StatusOK,
+			response: `{"output":{"text":"ok"}}`,
+			wantErr:  `missing key "content"`,
+		},
+		{
+			name:     "empty completion",
+			config:   Config{ResponsePath: "output.text"},
+			status:   http.StatusOK,
+			response: `{"output":{"text":null}}`,
+			wantErr:  "no response received",
+		},
+		{
+			name:     "error path",
+			config:   Config{ResponsePath: "output.text", ErrorPath: "error.message"},
+			status:   http.StatusOK,
+			response: `{"error":{"message":"quota exceeded"}}`,
+			wantErr:  "request failed: quota exceeded",
+		},
+		{
+			name:     "error status",
+			config:   Config{ResponsePath: "output.text"},
+			status:   http.StatusServiceUnavailable,
+			response: `maintenance`,
+			wantErr:  "maintenance",
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "stream",
+			config: Config{Stream: true, EventPath: "choices[0].delta.content", DoneData: "[DONE]"},
+			status: http.StatusOK,
+			response: "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"func \"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"TestX\"}}]}\n\n" +
+				"data: [DONE]\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"ignored\"}}]}\n\n",
+			want:       "func TestX",
+			wantTokens: []string{"func ", "TestX"},
+		},
+		{
+			name:     "stream with a wrong event path",
+			config:   Config{Stream: true, EventPath: "choices[0].text"},
+			status:   http.StatusOK,
+			response: "data: {\"choices\":[{\"delta\":{\"content\":\"func\"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\" TestX\"}}]}\n\n",
+			wantErr:  `no response received from the event stream: path choices[0].text not found: missing key "text"`,
+		},
+		{
+			name:     "stream error event",
+			config:   Config{Stream: true, EventPath: "text", ErrorPath: "error"},
+			status:   http.StatusOK,
+			response: "data: {\"text\":\"func\"}\n\ndata: {\"error\":\"overloaded\"}\n\n",
+			wantErr:  "request failed: overloaded",
+		},
+		{
+			name:     "empty stream",
+			config:   Config{Stream: true, EventPath: "text", DoneData: "[DONE]"},
+			status:   http.StatusOK,
+			response: "data: [DONE]\n\n",
+			wantErr:  "no response received from the event stream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut {
+					t.Errorf("method = %s, want PUT", r.Method)
+				}
+				if auth := r.Header.Get("Authorization"); auth != "Bearer gw-token" {
+					t.Errorf("Authorization = %q, want the rendered header template", auth)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				var req map[string]string
+				if err := json.Unmarshal(body, &req); err != nil {
+					t.Errorf("request body %s is not valid JSON: %v", body, err)
+				}
+				if req["input"] != "say \"hi\"\n" || req["model"] != "gw-model" {
+					t.Errorf("request = %v, want the escaped prompt and the model", req)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			t.Setenv("GATEWAY_TOKEN", "gw-token")
+			config := tt.config
+			config.URL = server.URL
+			config.Method = http.MethodPut
+			config.Model = "gw-model"
+			config.BodyTemplate = `{"input": "{{.Prompt}}", "model": "{{.Model}}"}`
+			config.Headers = map[string]string{"Authorization": `Bearer {{env "GATEWAY_TOKEN"}}`}
+			client, err := NewClient(config)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			var tokens []string
+			client.SetTokenHandler(func(token string) { tokens = append(tokens, token) })
+
+			resp, err := client.Chat(context.Background(), "say \"hi\"\n")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Chat() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				var httpErr *provider.HTTPError
+				if tt.wantCode != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantCode) {
+					t.Errorf("Chat() error = %v, want an HTTPError with status %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if resp != tt.want {
+				t.Errorf("Chat() = %q, want %q", resp, tt.want)
+			}
+			if strings.Join(tokens, "|") != strings.Join(tt.wantTokens, "|") {
+				t.Errorf("streamed tokens = %q, want %q", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestNewClientValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{name: "no url", config: Config{BodyTemplate: "{}", ResponsePath: "text"}, wantErr: "url is not configured"},
+		{name: "no body template", config: Config{URL: "http://x", ResponsePath: "text"}, wantErr: "body template is not configured"},
+		{name: "stream without event path", config: Config{URL: "http://x", BodyTemplate: "{}", Stream: true}, wantErr: "event path is required"},
+		{name: "no response path", config: Config{URL: "http://x", BodyTemplate: "{}"}, wantErr: "response path is not configured"},
+		{name: "invalid body template", config: Config{URL: "http://x", BodyTemplate: "{{", ResponsePath: "text"}, wantErr: "failed to parse body template"},
+		{name: "invalid header template", config: Config{URL: "http://x", BodyTemplate: "{}", ResponsePath: "text", Headers: map[string]string{"X": "{{"}}, wantErr: "failed to parse template of header X"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(tt.config); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewClient() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package customhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitPath splits a path such as "data.choices[0].text" or "data.choices.0.text" into its segments
+func splitPath(path string) []string {
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// extractPath parses the JSON document and returns the value found at the path as a string
+func extractPath(data []byte, path string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response body: %v", err)
+	}
+
+	value := doc
+	for _, segment := range splitPath(path) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return "", fmt.Errorf("path %s not found: missing key %q", path, segment)
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return "", fmt.Errorf("path %s not found: %q is not an array index", path, segment)
+			}
+			if index < 0 || index >= len(v) {
+				return "", fmt.Errorf("path %s not found: index %d out of range", path, index)
+			}
+			value = v[index]
+		default:
+			return "", fmt.Errorf("path %s not found: cannot select %q from %T", path, segment, value)
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
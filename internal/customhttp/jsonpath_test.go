@@ -0,0 +1,71 @@
+package customhttp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "text", want: []string{"text"}},
+		{path: "data.choices[0].text", want: []string{"data", "choices", "0", "text"}},
+		{path: "data.choices.0.text", want: []string{"data", "choices", "0", "text"}},
+		{path: "[1][2]", want: []string{"1", "2"}},
+		{path: ".data..text.", want: []string{"data", "text"}},
+		{path: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := splitPath(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	doc := `{"data":{"choices":[{"text":"first"},{"text":"second"}],"count":2,"done":true,"empty":null,"meta":{"id":"x"}}}`
+
+	tests := []struct {
+		name    string
+		data    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{name: "string leaf", data: doc, path: "data.choices[1].text", want: "second"},
+		{name: "dotted index", data: doc, path: "data.choices.0.text", want: "first"},
+		{name: "number leaf", data: doc, path: "data.count", want: "2"},
+		{name: "bool leaf", data: doc, path: "data.done", want: "true"},
+		{name: "null leaf", data: doc, path: "data.empty", want: ""},
+		{name: "object leaf", data: doc, path: "data.meta", want: `{"id":"x"}`},
+		{name: "empty path", data: `"raw"`, path: "", want: "raw"},
+		{name: "missing key", data: doc, path: "data.result", wantErr: `missing key "result"`},
+		{name: "index out of range", data: doc, path: "data.choices[2].text", wantErr: "index 2 out of range"},
+		{name: "negative index", data: doc, path: "data.choices[-1]", wantErr: "index -1 out of range"},
+		{name: "key on an array", data: doc, path: "data.choices.text", wantErr: `"text" is not an array index`},
+		{name: "select from a leaf", data: doc, path: "data.count.value", wantErr: `cannot select "value" from float64`},
+		{name: "invalid JSON", data: `{oops`, path: "data", wantErr: "failed to unmarshal response body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractPath([]byte(tt.data), tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractPath() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractPath() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("extractPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,714 @@
+// Package loader type-checks the package of a function, and resolves the identifiers of the function
+// to the declarations they refer to, wherever they are declared.
+//
+// The package is type-checked from source with go/types, its dependencies are imported from the export
+// data built by go list -export, as go/packages does. go/packages itself isn't used: the versions of
+// golang.org/x/tools which support the Go version of the module don't build with the recent toolchains.
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Package is a type-checked package
+type Package struct {
+	Path   string // Import path
+	Name   string
+	Dir    string
+	Files  []*ast.File
+	Types  *types.Package
+	Info   *types.Info
+	Errors []error // Type errors, the declarations which could be resolved are still usable
+}
+
+// listedPackage is the part of the output of go list -json used by the loader
+type listedPackage struct {
+	ImportPath      string
+	Name            string
+	Dir             string
+	GoFiles         []string
+	CgoFiles        []string
+	CompiledGoFiles []string
+	Export          string
+	ImportMap       map[string]string
+	DepOnly         bool
+	Error           *struct{ Err string }
+}
+
+// Kind is the kind of a declaration
+type Kind int
+
+const (
+	Type Kind = iota
+	Func
+	Method
+)
+
+// Decl is a declaration referenced by a function
+type Decl struct {
+	Kind    Kind
+	Package string // Name of the package, empty for the package of the function
+	PkgPath string // Import path of the package
+	Recv    string // Receiver type of a method
+	Name    string
+	Source  string // Source of the declaration, e.g. type T struct{...} or func (t *T) M() {...}
+	Field   string // Field of the receiver the type is reached through, e.g. repo or deps.repo
+	Via     string // Type whose definition references the nested type, e.g. model.User
+	Depth   int    // Number of definitions followed to reach the nested type, 0 for the other declarations
+}
+
+// QualifiedName returns the name of the declaration prefixed with its package and receiver, e.g. gorm.DB.Create
+func (d Decl) QualifiedName() string {
+	name := d.Name
+	if d.Recv != "" {
+		name = d.Recv + "." + name
+	}
+	if d.Package != "" {
+		name = d.Package + "." + name
+	}
+	return name
+}
+
+// Refs are the declarations referenced by a function, the standard library excluded
+type Refs struct {
+	SignatureTypes []Decl // Types of the receiver, parameters and results
+	Dependencies   []Decl // Types of the receiver fields whose methods are called, e.g. the interface of s.repo
+	Callees        []Decl // Functions and methods used in the body
+	BodyTypes      []Decl // Other types used in the body, including the interfaces whose methods are called
+	NestedTypes    []Decl // Types referenced by the definitions of the other types, up to the depth
+}
+
+// Loader loads each package once, it is safe for concurrent use
+type Loader struct {
+	fset *token.FileSet
+
+	packages sync.Map // Loaded packages, keyed by directory
+
+	mu    sync.Mutex
+	files map[string]*ast.File // Parsed files, keyed by file name
+}
+
+type loadedPackage struct {
+	once sync.Once
+	pkg  *Package
+	err  error
+}
+
+// New creates a Loader
+func New() *Loader {
+	return &Loader{
+		fset:  token.NewFileSet(),
+		files: make(map[string]*ast.File),
+	}
+}
+
+// Load type-checks the package in dir. The package is returned along with its errors if it has
+// type errors, the declarations which could be resolved are still usable.
+func (l *Loader) Load(dir string) (*Package, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, _ := l.packages.LoadOrStore(dir, &loadedPackage{})
+	lp := entry.(*loadedPackage)
+	lp.once.Do(func() {
+		lp.pkg, lp.err = l.load(dir)
+	})
+	return lp.pkg, lp.err
+}
+
+func (l *Loader) load(dir string) (*Package, error) {
+	listed, err := goList(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The dependencies are listed first, the package last
+	exports := make(map[string]string)
+	var target *listedPackage
+	for _, p := range listed {
+		if p.DepOnly {
+			exports[p.ImportPath] = p.Export
+			continue
+		}
+		target = p
+	}
+	if target == nil {
+		return nil, fmt.Errorf("no package found in %s", dir)
+	}
+	// Only the dependencies need to build, the type errors of the package are kept in Errors
+	if len(target.GoFiles) == 0 && len(target.CgoFiles) == 0 {
+		if target.Error != nil {
+			return nil, fmt.Errorf("failed to list package: %s", target.Error.Err)
+		}
+		// The test files are not part of the package, e.g. a directory of integration tests
+		return nil, fmt.Errorf("no non-test Go files in %s", dir)
+	}
+
+	pkg := &Package{
+		Path: target.ImportPath,
+		Name: target.Name,
+		Dir:  target.Dir,
+		Info: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Implicits:  make(map[ast.Node]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Scopes:     make(map[ast.Node]*types.Scope),
+		},
+	}
+
+	files := target.CompiledGoFiles
+	if len(files) == 0 {
+		files = target.GoFiles
+	}
+	for _, name := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(target.Dir, name)
+		}
+		file, err := parser.ParseFile(l.fset, name, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		pkg.Files = append(pkg.Files, file)
+	}
+
+	lookup := func(path string) (io.ReadCloser, error) {
+		if mapped, ok := target.ImportMap[path]; ok {
+			path = mapped
+		}
+		export := exports[path]
+		if export == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(export)
+	}
+	config := &types.Config{
+		Importer: importer.ForCompiler(l.fset, "gc", lookup),
+		Error:    func(err error) { pkg.Errors = append(pkg.Errors, err) },
+	}
+	pkg.Types, _ = config.Check(target.ImportPath, l.fset, pkg.Files, pkg.Info)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, file := range pkg.Files {
+		l.files[l.fset.Position(file.Package).Filename] = file
+	}
+	return pkg, nil
+}
+
+// goList lists the package in dir and its dependencies, with the export data of the dependencies
+func goList(dir string) ([]*listedPackage, error) {
+	cmd := exec.Command("go", "list", "-e", "-json", "-export", "-deps", "-compiled", ".")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var listed []*listedPackage
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var p listedPackage
+		if err := decoder.Decode(&p); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode the output of go list: %v", err)
+		}
+		listed = append(listed, &p)
+	}
+	return listed, nil
+}
+
+// FindFunc returns the declaration of the function whose name is at the line of the file
+func (l *Loader) FindFunc(pkg *Package, filename string, line int, name string) (*ast.FuncDecl, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range pkg.Files {
+		if l.fset.Position(file.Package).Filename != filename {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == name && l.fset.Position(fn.Name.Pos()).Line == line {
+				return fn, nil
+			}
+		}
+		return nil, fmt.Errorf("function %s not found at line %d of %s", name, line, filename)
+	}
+	return nil, fmt.Errorf("file %s is not part of package %s, it may be excluded by build constraints", filename, pkg.Path)
+}
+
+// References resolves the identifiers of the function to their declarations, including the methods
+// called on variables, fields and interfaces. The types referenced by the definitions of the types, such
+// as the types of their fields, are followed up to depth definitions, each type is only included once.
+func (l *Loader) References(pkg *Package, fn *ast.FuncDecl, depth int) (*Refs, error) {
+	info := pkg.Info
+	self := info.Defs[fn.Name]
+	seen := make(map[types.Object]bool)
+	if self != nil {
+		seen[self] = true
+	}
+
+	var refs Refs
+	var typeNames []*types.TypeName // Types included, in order, whose definitions are followed
+	add := func(list *[]Decl, obj types.Object, field string) *Decl {
+		if obj == nil || seen[obj] || !l.isRelevant(obj) {
+			return nil
+		}
+		seen[obj] = true
+
+		decl, err := l.declare(pkg, obj)
+		if err != nil || decl.Source == "" {
+			return nil
+		}
+		decl.Field = field
+		if typeName, ok := obj.(*types.TypeName); ok {
+			typeNames = append(typeNames, typeName)
+		}
+		*list = append(*list, decl)
+		return &(*list)[len(*list)-1]
+	}
+
+	// The types of the signature first, so they are not counted as body types
+	signature := []ast.Node{fn.Type}
+	if fn.Recv != nil {
+		signature = append(signature, fn.Recv)
+	}
+	for _, node := range signature {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if obj, ok := info.Uses[id].(*types.TypeName); ok {
+					add(&refs.SignatureTypes, obj, "")
+				}
+			}
+			return true
+		})
+	}
+
+	// The types of the receiver fields whose methods are called, such as s.repo.Get(ctx, id): the full
+	// declaration of an interface, or the declaration of a concrete type whose methods are callees
+	if recv := receiverVar(info, fn); recv != nil && fn.Body != nil {
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if field, ok := fieldPath(info, sel.X, recv); ok {
+				if named := namedType(info.TypeOf(sel.X)); named != nil {
+					add(&refs.Dependencies, named.Obj(), field)
+				}
+			}
+			return true
+		})
+	}
+
+	if fn.Body != nil {
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			switch obj := info.Uses[id].(type) {
+			case *types.TypeName:
+				add(&refs.BodyTypes, obj, "")
+			case *types.Func:
+				obj = obj.Origin()
+				sig := obj.Type().(*types.Signature)
+				if sig.Recv() != nil && types.IsInterface(sig.Recv().Type()) {
+					// The interface declares the method, it has no body
+					if named := namedType(sig.Recv().Type()); named != nil {
+						add(&refs.BodyTypes, named.Obj(), "")
+					}
+					return true
+				}
+				add(&refs.Callees, obj, "")
+			}
+			return true
+		})
+	}
+
+	// The seen objects collapse the types reached more than once and break the cycles
+	for d := 1; d <= depth && len(typeNames) > 0; d++ {
+		parents := typeNames
+		typeNames = nil
+		for _, parent := range parents {
+			via := parent.Name()
+			if parent.Pkg() != pkg.Types {
+				via = parent.Pkg().Name() + "." + via
+			}
+			for _, obj := range referencedTypes(parent) {
+				if decl := add(&refs.NestedTypes, obj, ""); decl != nil {
+					decl.Via = via
+					decl.Depth = d
+				}
+			}
+		}
+	}
+
+	for _, list := range [][]Decl{refs.SignatureTypes, refs.Dependencies, refs.Callees, refs.BodyTypes, refs.NestedTypes} {
+		sortDecls(list)
+	}
+	return &refs, nil
+}
+
+// Interfaces returns the interfaces the function depends on: the types of its parameters and of the
+// receiver fields whose methods are called, the standard library excluded
+func (l *Loader) Interfaces(pkg *Package, fn *ast.FuncDecl) []*types.Named {
+	info := pkg.Info
+	seen := make(map[*types.TypeName]bool)
+	var interfaces []*types.Named
+	add := func(t types.Type) {
+		named := namedType(t)
+		if named == nil || !types.IsInterface(named) || seen[named.Obj()] || !l.isRelevant(named.Obj()) {
+			return
+		}
+		seen[named.Obj()] = true
+		interfaces = append(interfaces, named)
+	}
+
+	for _, field := range fn.Type.Params.List {
+		add(info.TypeOf(field.Type))
+	}
+	if recv := receiverVar(info, fn); recv != nil && fn.Body != nil {
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+					if _, ok := fieldPath(info, sel.X, recv); ok {
+						add(info.TypeOf(sel.X))
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	sort.Slice(interfaces, func(i, j int) bool {
+		a, b := interfaces[i].Obj(), interfaces[j].Obj()
+		if a.Pkg().Path() != b.Pkg().Path() {
+			return a.Pkg().Path() < b.Pkg().Path()
+		}
+		return a.Name() < b.Name()
+	})
+	return interfaces
+}
+
+// referencedTypes returns the named types the definition of the type refers to, in order: the types of
+// the fields and embedded types of structs, the elements of slices, arrays, maps and channels, the
+// parameters and results of functions and interface methods, and the type arguments
+func referencedTypes(obj *types.TypeName) []*types.TypeName {
+	var refs []*types.TypeName
+	found := make(map[*types.TypeName]bool)
+	var walk func(t types.Type)
+	walk = func(t types.Type) {
+		switch t := t.(type) {
+		case *types.Named:
+			if tn := t.Origin().Obj(); !found[tn] {
+				found[tn] = true
+				refs = append(refs, tn)
+			}
+			args := t.TypeArgs()
+			for i := 0; i < args.Len(); i++ {
+				walk(args.At(i))
+			}
+		case *types.Pointer:
+			walk(t.Elem())
+		case *types.Slice:
+			walk(t.Elem())
+		case *types.Array:
+			walk(t.Elem())
+		case *types.Chan:
+			walk(t.Elem())
+		case *types.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				walk(t.Field(i).Type())
+			}
+		case *types.Signature:
+			for i := 0; i < t.Params().Len(); i++ {
+				walk(t.Params().At(i).Type())
+			}
+			for i := 0; i < t.Results().Len(); i++ {
+				walk(t.Results().At(i).Type())
+			}
+		case *types.Interface:
+			for i := 0; i < t.NumEmbeddeds(); i++ {
+				walk(t.EmbeddedType(i))
+			}
+			for i := 0; i < t.NumExplicitMethods(); i++ {
+				walk(t.ExplicitMethod(i).Type())
+			}
+		}
+	}
+
+	// The definition of a named type is its underlying type, an alias refers to the aliased type
+	if named, ok := obj.Type().(*types.Named); ok && named.Obj() == obj {
+		walk(named.Underlying())
+	} else {
+		walk(obj.Type())
+	}
+	return refs
+}
+
+// receiverVar returns the receiver variable of the method, nil for functions and unnamed receivers
+func receiverVar(info *types.Info, fn *ast.FuncDecl) types.Object {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 || len(fn.Recv.List[0].Names) == 0 {
+		return nil
+	}
+	return info.Defs[fn.Recv.List[0].Names[0]]
+}
+
+// fieldPath returns the path of the field selected from the receiver, e.g. deps.repo for s.deps.repo
+func fieldPath(info *types.Info, expr ast.Expr, recv types.Object) (string, bool) {
+	switch x := expr.(type) {
+	case *ast.ParenExpr:
+		return fieldPath(info, x.X, recv)
+	case *ast.SelectorExpr:
+		if sel := info.Selections[x]; sel == nil || sel.Kind() != types.FieldVal {
+			return "", false
+		}
+		if id, ok := x.X.(*ast.Ident); ok {
+			return x.Sel.Name, info.Uses[id] == recv
+		}
+		parent, ok := fieldPath(info, x.X, recv)
+		return parent + "." + x.Sel.Name, ok
+	}
+	return "", false
+}
+
+// isRelevant reports whether the object is declared at the package level outside of the standard library
+func (l *Loader) isRelevant(obj types.Object) bool {
+	if obj.Pkg() == nil || !obj.Pos().IsValid() {
+		return false // Universe objects, such as error
+	}
+	if _, isFunc := obj.(*types.Func); !isFunc && obj.Parent() != obj.Pkg().Scope() {
+		return false // Local types
+	}
+	return !isStandardLibrary(l.fset.Position(obj.Pos()).Filename)
+}
+
+// declare finds the declaration of the object and formats its source
+func (l *Loader) declare(pkg *Package, obj types.Object) (Decl, error) {
+	decl := Decl{Name: obj.Name(), PkgPath: obj.Pkg().Path()}
+	if obj.Pkg() != pkg.Types {
+		decl.Package = obj.Pkg().Name()
+	}
+
+	pos := l.fset.Position(obj.Pos())
+	file, err := l.file(pos.Filename)
+	if err != nil {
+		return decl, err
+	}
+
+	switch obj := obj.(type) {
+	case *types.TypeName:
+		decl.Kind = Type
+		spec := findTypeSpec(l.fset, file, obj.Name(), pos.Line)
+		if spec == nil {
+			return decl, fmt.Errorf("declaration of type %s not found in %s", obj.Name(), pos.Filename)
+		}
+		decl.Source, err = l.format(&ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{spec}})
+		if err == nil {
+			// The constants of an enum tell its values
+			if consts := l.constants(obj); consts != "" {
+				decl.Source += "\n\n" + consts
+			}
+		}
+	case *types.Func:
+		decl.Kind = Func
+		if recv := obj.Type().(*types.Signature).Recv(); recv != nil {
+			decl.Kind = Method
+			if named := namedType(recv.Type()); named != nil {
+				decl.Recv = named.Obj().Name()
+			}
+		}
+		fn := findFuncDecl(l.fset, file, obj.Name(), pos.Line)
+		if fn == nil {
+			return decl, fmt.Errorf("declaration of function %s not found in %s", obj.Name(), pos.Filename)
+		}
+		decl.Source, err = l.format(fn)
+	}
+	return decl, err
+}
+
+// constants returns the declarations of the constants of the type, for the types whose underlying type is basic
+func (l *Loader) constants(obj *types.TypeName) string {
+	if _, ok := obj.Type().Underlying().(*types.Basic); !ok {
+		return ""
+	}
+
+	scope := obj.Pkg().Scope()
+	seen := make(map[*ast.GenDecl]bool)
+	var gens []*ast.GenDecl
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), obj.Type()) {
+			continue
+		}
+		pos := l.fset.Position(c.Pos())
+		file, err := l.file(pos.Filename)
+		if err != nil {
+			continue
+		}
+		gen := findConstDecl(l.fset, file, name, pos.Line)
+		if gen == nil || seen[gen] {
+			continue
+		}
+		seen[gen] = true
+		gens = append(gens, gen)
+	}
+
+	// In the order they are declared
+	sort.Slice(gens, func(i, j int) bool {
+		a, b := l.fset.Position(gens[i].Pos()), l.fset.Position(gens[j].Pos())
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Line < b.Line
+	})
+	var decls []string
+	for _, gen := range gens {
+		if source, err := l.format(gen); err == nil {
+			decls = append(decls, source)
+		}
+	}
+	return strings.Join(decls, "\n\n")
+}
+
+// file returns the syntax of the file, the files outside of the loaded packages are parsed on demand
+func (l *Loader) file(filename string) (*ast.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if file, ok := l.files[filename]; ok {
+		return file, nil
+	}
+	file, err := parser.ParseFile(l.fset, filename, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+	l.files[filename] = file
+	return file, nil
+}
+
+func (l *Loader) format(node ast.Node) (string, error) {
+	var sb strings.Builder
+	if err := format.Node(&sb, l.fset, node); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// findTypeSpec finds the type by name, preferring the one at the line, as the positions read from
+// the export data of the dependencies only have a line
+func findTypeSpec(fset *token.FileSet, file *ast.File, name string, line int) *ast.TypeSpec {
+	var found *ast.TypeSpec
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			if ts := spec.(*ast.TypeSpec); ts.Name.Name == name {
+				if fset.Position(ts.Name.Pos()).Line == line {
+					return ts
+				}
+				found = ts
+			}
+		}
+	}
+	return found
+}
+
+// findConstDecl finds the declaration of the constant, preferring the one at the line
+func findConstDecl(fset *token.FileSet, file *ast.File, name string, line int) *ast.GenDecl {
+	var found *ast.GenDecl
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, id := range spec.(*ast.ValueSpec).Names {
+				if id.Name == name {
+					if fset.Position(id.Pos()).Line == line {
+						return gen
+					}
+					found = gen
+				}
+			}
+		}
+	}
+	return found
+}
+
+// findFuncDecl finds the function or method at the line
+func findFuncDecl(fset *token.FileSet, file *ast.File, name string, line int) *ast.FuncDecl {
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == name && fset.Position(fn.Name.Pos()).Line == line {
+			return fn
+		}
+	}
+	return nil
+}
+
+// namedType returns the named type of t, dereferencing pointers
+func namedType(t types.Type) *types.Named {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Origin()
+	}
+	return nil
+}
+
+// isStandardLibrary reports whether the file belongs to GOROOT
+func isStandardLibrary(filename string) bool {
+	if strings.HasPrefix(filename, "$GOROOT") {
+		return true
+	}
+	goroot := filepath.Clean(build.Default.GOROOT)
+	return goroot != "." && strings.HasPrefix(filename, goroot+string(filepath.Separator))
+}
+
+// sortDecls sorts the declarations by depth, then by package, the package of the function first, then by name
+func sortDecls(decls []Decl) {
+	sort.Slice(decls, func(i, j int) bool {
+		if decls[i].Depth != decls[j].Depth {
+			return decls[i].Depth < decls[j].Depth
+		}
+		if decls[i].Package != decls[j].Package {
+			return decls[i].Package < decls[j].Package
+		}
+		return decls[i].QualifiedName() < decls[j].QualifiedName()
+	})
+}
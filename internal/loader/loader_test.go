@@ -0,0 +1,235 @@
+package loader
+
+import (
+	"go/ast"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeModule writes the files of a module example.com/m to a temporary directory, keyed by their relative path
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	files["go.mod"] = "module example.com/m\n\ngo 1.20\n"
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// findFunc loads the package in dir and returns the function declared at the line of the file
+func findFunc(t *testing.T, l *Loader, dir, file string, line int, name string) (*Package, *ast.FuncDecl) {
+	t.Helper()
+	pkg, err := l.Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	fn, err := l.FindFunc(pkg, filepath.Join(dir, file), line, name)
+	if err != nil {
+		t.Fatalf("FindFunc() error = %v", err)
+	}
+	return pkg, fn
+}
+
+// names returns the qualified names of the declarations
+func names(decls []Decl) []string {
+	var names []string
+	for _, decl := range decls {
+		names = append(names, decl.QualifiedName())
+	}
+	return names
+}
+
+const storeSource = `package store
+
+// Store persists the users
+type Store interface {
+	Get(id int) (*User, error)
+}
+
+type User struct {
+	ID      int
+	Profile Profile
+}
+
+type Profile struct {
+	Address Address
+}
+
+type Address struct {
+	City string
+}
+
+// Open opens the store
+func Open(dsn string) Store { return nil }
+`
+
+const serviceSource = `package service
+
+import (
+	"strings"
+
+	"example.com/m/store"
+)
+
+type Service struct {
+	repo store.Store
+}
+
+func (s *Service) Name(id int) (string, error) {
+	user, err := s.repo.Get(id)
+	if err != nil {
+		return "", err
+	}
+	_ = store.Open(strings.TrimSpace(" dsn "))
+	return user.Profile.Address.City, nil
+}
+`
+
+func TestLoadDependencies(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"store/store.go":     storeSource,
+		"service/service.go": serviceSource,
+	})
+	l := New()
+	pkg, fn := findFunc(t, l, filepath.Join(root, "service"), "service.go", 13, "Name")
+	if len(pkg.Errors) > 0 {
+		t.Fatalf("Load() type errors = %v", pkg.Errors)
+	}
+	if pkg.Path != "example.com/m/service" || pkg.Name != "service" {
+		t.Errorf("Load() = %s %s, want package service of example.com/m/service", pkg.Path, pkg.Name)
+	}
+
+	refs, err := l.References(pkg, fn, 0)
+	if err != nil {
+		t.Fatalf("References() error = %v", err)
+	}
+	want := map[string][]string{
+		"SignatureTypes": {"Service"},
+		"Dependencies":   {"store.Store"},
+		"Callees":        {"store.Open"},
+		"BodyTypes":      nil,
+	}
+	got := map[string][]string{
+		"SignatureTypes": names(refs.SignatureTypes),
+		"Dependencies":   names(refs.Dependencies),
+		"Callees":        names(refs.Callees),
+		"BodyTypes":      names(refs.BodyTypes),
+	}
+	for kind, w := range want {
+		if strings.Join(got[kind], ",") != strings.Join(w, ",") {
+			t.Errorf("References() %s = %v, want %v", kind, got[kind], w)
+		}
+	}
+
+	// The declarations of the dependency are read from its source, found through the export data
+	if len(refs.Dependencies) == 1 {
+		decl := refs.Dependencies[0]
+		if decl.PkgPath != "example.com/m/store" || decl.Field != "repo" || !strings.Contains(decl.Source, "Get(id int) (*User, error)") {
+			t.Errorf("References() dependency = %+v, want the Store interface reached through the repo field", decl)
+		}
+	}
+	if len(refs.Callees) == 1 && !strings.HasPrefix(refs.Callees[0].Source, "// Open opens the store\nfunc Open(dsn string) Store") &&
+		!strings.HasPrefix(refs.Callees[0].Source, "func Open(dsn string) Store") {
+		t.Errorf("References() callee source = %q, want the declaration of store.Open", refs.Callees[0].Source)
+	}
+}
+
+func TestReferencesDepth(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"store/store.go":     storeSource,
+		"service/service.go": serviceSource,
+	})
+	l := New()
+	pkg, fn := findFunc(t, l, filepath.Join(root, "service"), "service.go", 13, "Name")
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{depth: 0, want: nil},
+		{depth: 1, want: []string{"store.User"}},
+		{depth: 2, want: []string{"store.User", "store.Profile"}},
+		{depth: 4, want: []string{"store.User", "store.Profile", "store.Address"}},
+	}
+	for _, tt := range tests {
+		refs, err := l.References(pkg, fn, tt.depth)
+		if err != nil {
+			t.Fatalf("References(%d) error = %v", tt.depth, err)
+		}
+		var got []string
+		for _, decl := range refs.NestedTypes {
+			got = append(got, decl.QualifiedName())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("References(%d) NestedTypes = %v, want %v", tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestLoadPackages(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"cgo/cgo.go":         "package cgo\n\n// int twice(int x) { return 2 * x; }\nimport \"C\"\n\nfunc Twice(x int) int { return int(C.twice(C.int(x))) }\n",
+		"cgo/plain.go":       "package cgo\n\nfunc Half(x int) int { return x / 2 }\n",
+		"cgoonly/cgo.go":     "package cgoonly\n\n// int one(void) { return 1; }\nimport \"C\"\n\nfunc One() int { return int(C.one()) }\n",
+		"testonly/x_test.go": "package testonly\n\nimport \"testing\"\n\nfunc TestX(t *testing.T) {}\n",
+		"broken/broken.go":   "package broken\n\nfunc Broken() int { return undefined }\n\nfunc Fine() int { return 1 }\n",
+		"empty/README":       "nothing to build\n",
+	})
+
+	tests := []struct {
+		name       string
+		dir        string
+		file       string
+		line       int
+		funcName   string
+		wantErr    string
+		cgo        bool // Whether the package needs cgo
+		wantErrors bool // Whether the package is loaded with type errors
+	}{
+		{name: "cgo", dir: "cgo", file: "cgo.go", line: 6, funcName: "Twice", cgo: true},
+		{name: "file next to cgo", dir: "cgo", file: "plain.go", line: 3, funcName: "Half", cgo: true},
+		{name: "cgo files only", dir: "cgoonly", file: "cgo.go", line: 6, funcName: "One", cgo: true},
+		{name: "test-only package", dir: "testonly", wantErr: "no non-test Go files"},
+		{name: "type errors", dir: "broken", file: "broken.go", line: 5, funcName: "Fine", wantErrors: true},
+		{name: "no Go files", dir: "empty", wantErr: "failed to list package"},
+	}
+
+	output, err := exec.Command("go", "env", "CGO_ENABLED").Output()
+	cgoEnabled := err == nil && strings.TrimSpace(string(output)) == "1"
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cgo && !cgoEnabled {
+				t.Skip("cgo is disabled")
+			}
+			l := New()
+			dir := filepath.Join(root, tt.dir)
+			pkg, err := l.Load(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got := len(pkg.Errors) > 0; got != tt.wantErrors {
+				t.Errorf("Load() type errors = %v, want errors %v", pkg.Errors, tt.wantErrors)
+			}
+			if _, err := l.FindFunc(pkg, filepath.Join(dir, tt.file), tt.line, tt.funcName); err != nil {
+				t.Errorf("FindFunc() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"smart-testify/internal/provider"
+	"strings"
+)
+
+// ProviderName is the name the local model client is registered with
+const ProviderName = "local"
+
+const (
+	// BackendOllama talks to the Ollama /api/chat endpoint
+	BackendOllama = "ollama"
+	// BackendLlamaCpp talks to the llama.cpp server /v1/chat/completions endpoint, which applies the chat template of the model
+	BackendLlamaCpp = "llamacpp"
+)
+
+// Backends lists the supported local servers
+var Backends = []string{BackendOllama, BackendLlamaCpp}
+
+var defaultEndpoints = map[string]string{
+	BackendOllama:   "http://localhost:11434",
+	BackendLlamaCpp: "http://localhost:8080",
+}
+
+// Config holds the settings of a model served on the local machine
+type Config struct {
+	Backend     string  `json:"backend"`
+	Endpoint    string  `json:"endpoint"`
+	Model       string  `json:"model"`
+	ContextSize int     `json:"context_size"`
+	Temperature float64 `json:"temperature"`
+}
+
+// Client talks to a local Ollama or llama.cpp server, so the code never leaves the machine
+type Client struct {
+	Config     Config
+	HTTPClient *http.Client
+}
+
+// NewClient initializes a Client instance
+func NewClient(config Config) *Client {
+	if config.Backend == "" {
+		config.Backend = BackendOllama
+	}
+	return &Client{
+		Config:     config,
+		HTTPClient: &http.Client{},
+	}
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaOptions struct {
+	NumCtx      int     `json:"num_ctx,omitempty"`
+	Temperature float64 `json:"temperature"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaResponse struct {
+	Message ollamaMessage `json:"message"`
+	Error   string        `json:"error"`
+}
+
+type llamaCppRequest struct {
+	Messages    []ollamaMessage `json:"messages"`
+	Temperature float64         `json:"temperature"`
+	CachePrompt bool            `json:"cache_prompt"`
+}
+
+type llamaCppResponse struct {
+	Choices []struct {
+		Message ollamaMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// Name returns the provider name of the client
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// Capabilities returns the features supported by the local server
+func (c *Client) Capabilities() provider.Capabilities {
+	return provider.Capabilities{}
+}
+
+// Limits returns the context size configured for the local model
+func (c *Client) Limits() provider.Limits {
+	return provider.Limits{
+		Model:         c.Config.Model,
+		ContextTokens: c.Config.ContextSize,
+	}
+}
+
+// Chat sends the prompt to the local server and returns the completion
+func (c *Client) Chat(ctx context.Context, prompt string) (string, error) {
+	switch c.Config.Backend {
+	case BackendOllama:
+		if c.Config.Model == "" {
+			return "", errors.New("model is not configured, please run 'smart-testify config local set --model <name>' to set it")
+		}
+		return c.chatOllama(ctx, prompt)
+	case BackendLlamaCpp:
+		return c.chatLlamaCpp(ctx, prompt)
+	default:
+		return "", fmt.Errorf("unsupported local backend: %s, must be one of %v", c.Config.Backend, Backends)
+	}
+}
+
+func (c *Client) chatOllama(ctx context.Context, prompt string) (string, error) {
+	body, err := c.post(ctx, "/api/chat", ollamaRequest{
+		Model:    c.Config.Model,
+		Messages: []ollamaMessage{{Role: "user", Content: prompt}},
+		Stream:   false,
+		Options: ollamaOptions{
+			NumCtx:      c.Config.ContextSize,
+			Temperature: c.Config.Temperature,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var resp ollamaResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response body: %v", err)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("request failed: %s", resp.Error)
+	}
+	if resp.Message.Content == "" {
+		return "", fmt.Errorf("no response received, response body: %s", string(body))
+	}
+	return resp.Message.Content, nil
+}
+
+func (c *Client) chatLlamaCpp(ctx context.Context, prompt string) (string, error) {
+	// The context size of llama.cpp is fixed when the server starts, so it is not sent here
+	body, err := c.post(ctx, "/v1/chat/completions", llamaCppRequest{
+		Messages:    []ollamaMessage{{Role: "user", Content: prompt}},
+		Temperature: c.Config.Temperature,
+		CachePrompt: true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var resp llamaCppResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response body: %v", err)
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("no response received, response body: %s", string(body))
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// post sends the request body as JSON to the given path of the endpoint and returns the response body
+func (c *Client) post(ctx context.Context, path string, reqBody interface{}) ([]byte, error) {
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint()+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to %s, is the %s server running? %w", c.endpoint(), c.Config.Backend, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, provider.NewHTTPError(resp, body)
+	}
+	return body, nil
+}
+
+func (c *Client) endpoint() string {
+	endpoint := c.Config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoints[c.Config.Backend]
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
@@ -0,0 +1,136 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"smart-testify/internal/provider"
+	"strings"
+	"testing"
+)
+
+func TestClientChat(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  string
+		model    string
+		path     string // Path the fake server expects
+		status   int
+		response string
+		want     string
+		wantErr  string
+		wantCode int // Status of the HTTPError expected, 0 if none
+	}{
+		{
+			name:     "ollama",
+			backend:  BackendOllama,
+			model:    "llama3",
+			path:     "/api/chat",
+			status:   http.StatusOK,
+			response: `{"message":{"role":"assistant","content":"func TestX(t *testing.T) {}"}}`,
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "ollama error in the body",
+			backend:  BackendOllama,
+			model:    "llama3",
+			path:     "/api/chat",
+			status:   http.StatusOK,
+			response: `{"error":"model 'llama3' not found"}`,
+			wantErr:  "model 'llama3' not found",
+		},
+		{
+			name:     "ollama error status",
+			backend:  BackendOllama,
+			model:    "llama3",
+			path:     "/api/chat",
+			status:   http.StatusInternalServerError,
+			response: `boom`,
+			wantErr:  "boom",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:    "ollama without model",
+			backend: BackendOllama,
+			wantErr: "model is not configured",
+		},
+		{
+			name:     "llama.cpp",
+			backend:  BackendLlamaCpp,
+			path:     "/v1/chat/completions",
+			status:   http.StatusOK,
+			response: `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`,
+			want:     "ok",
+		},
+		{
+			name:     "llama.cpp empty choices",
+			backend:  BackendLlamaCpp,
+			path:     "/v1/chat/completions",
+			status:   http.StatusOK,
+			response: `{"choices":[]}`,
+			wantErr:  "no response received",
+		},
+		{
+			name:     "llama.cpp error status",
+			backend:  BackendLlamaCpp,
+			path:     "/v1/chat/completions",
+			status:   http.StatusServiceUnavailable,
+			response: `{"error":{"message":"loading model"}}`,
+			wantErr:  "loading model",
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:    "unsupported backend",
+			backend: "vllm",
+			wantErr: "unsupported local backend",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != tt.path {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("failed to decode the request: %v", err)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			client := NewClient(Config{Backend: tt.backend, Endpoint: server.URL + "/", Model: tt.model})
+			resp, err := client.Chat(context.Background(), "prompt")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Chat() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				var httpErr *provider.HTTPError
+				if tt.wantCode != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantCode) {
+					t.Errorf("Chat() error = %v, want an HTTPError with status %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if resp != tt.want {
+				t.Errorf("Chat() = %q, want %q", resp, tt.want)
+			}
+
+			// The prompt is sent as a user message, the server applies the chat template
+			messages, _ := got["messages"].([]interface{})
+			if len(messages) != 1 {
+				t.Fatalf("request messages = %v, want one message", got["messages"])
+			}
+			message := messages[0].(map[string]interface{})
+			if message["role"] != "user" || message["content"] != "prompt" {
+				t.Errorf("request message = %v, want the prompt as a user message", message)
+			}
+		})
+	}
+}
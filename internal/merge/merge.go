@@ -0,0 +1,430 @@
+package merge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// Mode tells what to do with a generated function which already exists in the test file
+type Mode int
+
+const (
+	// Append merges the generated function into the existing one as subtests
+	Append Mode = iota
+	// Replace replaces the existing function with the generated one
+	Replace
+)
+
+// ErrConflict is returned when the generated declarations can't be merged without declaring a name twice
+var ErrConflict = errors.New("conflicting declarations")
+
+// Report lists what the merge did, by declaration name
+type Report struct {
+	Added    []string
+	Replaced []string
+	Merged   []string          // Test functions which received the generated subtests
+	Renamed  map[string]string // Generated helpers which clash with a different existing one, to their new name
+	Skipped  []string          // Methods which clash with a different existing one
+}
+
+// edit replaces src[start:end] with text
+type edit struct {
+	start, end int
+	text       string
+	order      int
+}
+
+// Merge merges the generated declarations into the source of a test file. The source is edited as
+// text at the positions of its declarations, so its comments and layout are kept. The imports of the
+// generated code are ignored, goimports is expected to run on the result. The generated helpers which
+// clash with a different existing declaration are renamed along with their uses in the generated code,
+// and ErrConflict is returned if the generated code declares a name twice.
+func Merge(src, generated string, mode Mode) (string, *Report, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse test file: %v", err)
+	}
+
+	genSrc := generated
+	if !strings.HasPrefix(strings.TrimSpace(generated), "package ") {
+		genSrc = "package " + file.Name.Name + "\n\n" + generated
+	}
+	genFset := token.NewFileSet()
+	genFile, err := parser.ParseFile(genFset, "", genSrc, parser.ParseComments)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse generated code: %v", err)
+	}
+
+	existing := make(map[string]ast.Decl)
+	for _, decl := range file.Decls {
+		for _, name := range declNames(decl) {
+			existing[name] = decl
+		}
+	}
+
+	report := &Report{}
+	if renamed := renameClashes(fset, existing, genFset, genFile, genSrc); renamed != nil {
+		genSrc = renamed.src
+		report.Renamed = renamed.names
+		genFset = token.NewFileSet()
+		genFile, err = parser.ParseFile(genFset, "", genSrc, parser.ParseComments)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse renamed generated code: %v", err)
+		}
+	}
+
+	var edits []edit
+	var appended []string
+	declared := make(map[string]ast.Decl)
+	for _, decl := range genFile.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		text := declText(genFset, genSrc, decl)
+		names := declNames(decl)
+
+		// The same declaration generated twice is kept once, different ones can't both be kept
+		duplicate := false
+		for _, name := range names {
+			prev, ok := declared[name]
+			if !ok {
+				continue
+			}
+			if !sameCode(genFset, prev, genFset, decl) {
+				return "", nil, fmt.Errorf("%w: %s is declared twice in the generated code", ErrConflict, name)
+			}
+			duplicate = true
+		}
+		if duplicate {
+			continue
+		}
+		for _, name := range names {
+			declared[name] = decl
+		}
+
+		var clash ast.Decl
+		for _, name := range names {
+			if old, ok := existing[name]; ok {
+				clash = old
+				break
+			}
+		}
+
+		switch {
+		case clash == nil:
+			appended = append(appended, text)
+			report.Added = append(report.Added, names...)
+		case sameCode(fset, clash, genFset, decl):
+			// Already there, e.g. a helper generated again
+		case mode == Replace:
+			start, end := declRange(fset, clash)
+			edits = append(edits, edit{start: start, end: end, text: text})
+			report.Replaced = append(report.Replaced, names...)
+		default:
+			oldFn, oldOk := clash.(*ast.FuncDecl)
+			newFn, newOk := decl.(*ast.FuncDecl)
+			if !oldOk || !newOk || oldFn.Recv != nil || !isTestFunc(oldFn) {
+				report.Skipped = append(report.Skipped, names...)
+				continue
+			}
+			insert, err := subtests(genFset, genSrc, oldFn, newFn)
+			if err != nil {
+				report.Skipped = append(report.Skipped, names...)
+				continue
+			}
+			pos := fset.Position(oldFn.Body.Rbrace).Offset
+			edits = append(edits, edit{start: pos, end: pos, text: "\n" + insert + "\n"})
+			report.Merged = append(report.Merged, names...)
+		}
+	}
+
+	result := applyEdits(src, edits)
+	if len(appended) > 0 {
+		result = strings.TrimRight(result, "\n") + "\n\n" + strings.Join(appended, "\n\n") + "\n"
+	}
+	return result, report, nil
+}
+
+// applyEdits applies the edits from the end so the offsets stay valid, the insertions at the same
+// position are applied in reverse so they end up in the order of the edits
+func applyEdits(src string, edits []edit) string {
+	for i := range edits {
+		edits[i].order = i
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start > edits[j].start
+		}
+		return edits[i].order > edits[j].order
+	})
+	for _, e := range edits {
+		src = src[:e.start] + e.text + src[e.end:]
+	}
+	return src
+}
+
+// renamedCode is the generated code with its clashing helpers renamed
+type renamedCode struct {
+	src   string
+	names map[string]string // Old name to new name
+}
+
+// renameClashes renames the generated functions, types, variables and constants which clash with a
+// different existing declaration, and the identifiers of the generated code which refer to them, so
+// the generated tests don't call the existing ones. Test functions and methods are not renamed.
+func renameClashes(fset *token.FileSet, existing map[string]ast.Decl, genFset *token.FileSet, genFile *ast.File, genSrc string) *renamedCode {
+	taken := make(map[string]bool)
+	for name := range existing {
+		taken[name] = true
+	}
+	for _, decl := range genFile.Decls {
+		for _, name := range declNames(decl) {
+			taken[name] = true
+		}
+	}
+
+	renames := make(map[*ast.Object]string)
+	names := make(map[string]string)
+	for _, decl := range genFile.Decls {
+		for _, ident := range renamableIdents(decl) {
+			old, ok := existing[ident.Name]
+			if !ok || ident.Obj == nil || sameCode(fset, old, genFset, decl) {
+				continue
+			}
+			name := freeName(ident.Name, taken)
+			taken[name] = true
+			renames[ident.Obj] = name
+			names[ident.Name] = name
+		}
+	}
+	if len(renames) == 0 {
+		return nil
+	}
+
+	var edits []edit
+	ast.Inspect(genFile, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil {
+			if name, ok := renames[ident.Obj]; ok {
+				start := genFset.Position(ident.Pos()).Offset
+				edits = append(edits, edit{start: start, end: start + len(ident.Name), text: name})
+			}
+		}
+		return true
+	})
+	return &renamedCode{src: applyEdits(genSrc, edits), names: names}
+}
+
+// renamableIdents returns the identifiers declared by the declaration which can be renamed
+func renamableIdents(decl ast.Decl) []*ast.Ident {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv == nil && !isTestFunc(d) {
+			return []*ast.Ident{d.Name}
+		}
+	case *ast.GenDecl:
+		var idents []*ast.Ident
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				idents = append(idents, s.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					if name.Name != "_" {
+						idents = append(idents, name)
+					}
+				}
+			}
+		}
+		return idents
+	}
+	return nil
+}
+
+// freeName returns the name suffixed with the first number which makes it unused
+func freeName(name string, taken map[string]bool) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s%d", name, i)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+// subtests returns the statements to insert at the end of the existing test function. The t.Run calls
+// of the generated function are used as they are, any other body is wrapped in a new subtest.
+func subtests(fset *token.FileSet, src string, oldFn, newFn *ast.FuncDecl) (string, error) {
+	oldT := testingParam(oldFn)
+	newT := testingParam(newFn)
+	if oldT == "" || newT == "" {
+		return "", errors.New("the test functions have no named *testing.T parameter")
+	}
+	if newFn.Body == nil || len(newFn.Body.List) == 0 {
+		return "", errors.New("the generated test function is empty")
+	}
+
+	stmts := newFn.Body.List
+	start := fset.Position(stmts[0].Pos()).Offset
+	end := fset.Position(stmts[len(stmts)-1].End()).Offset
+	body := src[start:end]
+
+	if oldT == newT && allSubtests(stmts, newT) {
+		return "\t" + body, nil
+	}
+	return fmt.Sprintf("\t%s.Run(%q, func(%s *testing.T) {\n\t\t%s\n\t})", oldT, newFn.Name.Name, newT, body), nil
+}
+
+// allSubtests reports whether every statement is a call to t.Run
+func allSubtests(stmts []ast.Stmt, t string) bool {
+	for _, stmt := range stmts {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			return false
+		}
+		call, ok := expr.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Run" {
+			return false
+		}
+		if ident, ok := sel.X.(*ast.Ident); !ok || ident.Name != t {
+			return false
+		}
+	}
+	return true
+}
+
+// testingParam returns the name of the *testing.T parameter of the test function
+func testingParam(fn *ast.FuncDecl) string {
+	params := fn.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) != 1 || params[0].Names[0].Name == "_" {
+		return ""
+	}
+	return params[0].Names[0].Name
+}
+
+func isTestFunc(fn *ast.FuncDecl) bool {
+	return strings.HasPrefix(fn.Name.Name, "Test")
+}
+
+// declNames returns the names declared by the declaration, methods are named Type.Method
+func declNames(decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return []string{recvTypeName(d.Recv.List[0].Type) + "." + d.Name.Name}
+		}
+		return []string{d.Name.Name}
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					if name.Name != "_" {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func recvTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return recvTypeName(t.X)
+	case *ast.IndexExpr:
+		return recvTypeName(t.X)
+	case *ast.IndexListExpr:
+		return recvTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// declRange returns the offsets of the declaration, including its doc comment
+func declRange(fset *token.FileSet, decl ast.Decl) (int, int) {
+	start := decl.Pos()
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	}
+	return fset.Position(start).Offset, fset.Position(decl.End()).Offset
+}
+
+func declText(fset *token.FileSet, src string, decl ast.Decl) string {
+	start, end := declRange(fset, decl)
+	return src[start:end]
+}
+
+// sameCode reports whether both declarations are the same once formatted
+func sameCode(fset1 *token.FileSet, decl1 ast.Decl, fset2 *token.FileSet, decl2 ast.Decl) bool {
+	var buf1, buf2 bytes.Buffer
+	if format.Node(&buf1, fset1, decl1) != nil || format.Node(&buf2, fset2, decl2) != nil {
+		return false
+	}
+	return buf1.String() == buf2.String()
+}
+
+// AddImports adds the import paths missing from the source, into its last parenthesized import
+// declaration, or into a new one after the existing imports
+func AddImports(src string, paths []string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse test file: %v", err)
+	}
+
+	imported := make(map[string]bool)
+	for _, imp := range file.Imports {
+		imported[strings.Trim(imp.Path.Value, `"`)] = true
+	}
+	var lines strings.Builder
+	for _, path := range paths {
+		if !imported[path] {
+			imported[path] = true
+			fmt.Fprintf(&lines, "\t%q\n", path)
+		}
+	}
+	if lines.Len() == 0 {
+		return src, nil
+	}
+
+	var last *ast.GenDecl
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			last = gen
+		}
+	}
+	if last != nil && last.Rparen.IsValid() {
+		pos := fset.Position(last.Rparen).Offset
+		return src[:pos] + lines.String() + src[pos:], nil
+	}
+
+	pos := fset.Position(file.Name.End()).Offset
+	if last != nil {
+		pos = fset.Position(last.End()).Offset
+	}
+	return src[:pos] + "\n\nimport (\n" + lines.String() + ")" + src[pos:], nil
+}
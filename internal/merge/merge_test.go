@@ -0,0 +1,232 @@
+package merge
+
+import (
+	"errors"
+	"go/format"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const existingFile = `package p
+
+import "testing"
+
+// TestAdd checks Add
+func TestAdd(t *testing.T) {
+	t.Run("zero", func(t *testing.T) {})
+}
+
+func newUser() *User {
+	return &User{}
+}
+
+type fakeStore struct{}
+
+func (s *fakeStore) Get() int { return 1 }
+
+var (
+	minID = 1
+	maxID = 9
+)
+`
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		generated string
+		mode      Mode
+		want      string
+		report    Report
+	}{
+		{
+			name:      "new test is appended",
+			generated: "func TestSub(t *testing.T) {\n\tt.Run(\"one\", func(t *testing.T) {})\n}",
+			mode:      Append,
+			want: existingFile + `
+func TestSub(t *testing.T) {
+	t.Run("one", func(t *testing.T) {})
+}
+`,
+			report: Report{Added: []string{"TestSub"}},
+		},
+		{
+			name:      "subtests are merged into the existing test",
+			generated: "func TestAdd(t *testing.T) {\n\tt.Run(\"negative\", func(t *testing.T) {})\n}",
+			mode:      Append,
+			want: strings.Replace(existingFile, `	t.Run("zero", func(t *testing.T) {})
+`, `	t.Run("zero", func(t *testing.T) {})
+
+	t.Run("negative", func(t *testing.T) {})
+`, 1),
+			report: Report{Merged: []string{"TestAdd"}},
+		},
+		{
+			name:      "a body without subtests is wrapped in one",
+			generated: "func TestAdd(t *testing.T) {\n\tif Add(1, 1) != 2 {\n\t\tt.Fail()\n\t}\n}",
+			mode:      Append,
+			want: strings.Replace(existingFile, `	t.Run("zero", func(t *testing.T) {})
+`, `	t.Run("zero", func(t *testing.T) {})
+
+	t.Run("TestAdd", func(t *testing.T) {
+		if Add(1, 1) != 2 {
+			t.Fail()
+		}
+	})
+`, 1),
+			report: Report{Merged: []string{"TestAdd"}},
+		},
+		{
+			name:      "the existing test is replaced with its doc comment",
+			generated: "func TestAdd(t *testing.T) {\n\tt.Run(\"new\", func(t *testing.T) {})\n}",
+			mode:      Replace,
+			want: strings.Replace(existingFile, `// TestAdd checks Add
+func TestAdd(t *testing.T) {
+	t.Run("zero", func(t *testing.T) {})
+}`, `func TestAdd(t *testing.T) {
+	t.Run("new", func(t *testing.T) {})
+}`, 1),
+			report: Report{Replaced: []string{"TestAdd"}},
+		},
+		{
+			name:      "an identical helper is not declared again",
+			generated: "func newUser() *User {\n\treturn &User{}\n}\n\nfunc TestSub(t *testing.T) {}",
+			mode:      Append,
+			want:      existingFile + "\nfunc TestSub(t *testing.T) {}\n",
+			report:    Report{Added: []string{"TestSub"}},
+		},
+		{
+			name:      "a different helper is renamed with its uses",
+			generated: "func newUser() *User {\n\treturn &User{Name: \"x\"}\n}\n\nfunc TestSub(t *testing.T) {\n\tnewUser := newUser()\n\t_ = newUser\n}",
+			mode:      Append,
+			want: existingFile + `
+func newUser2() *User {
+	return &User{Name: "x"}
+}
+
+func TestSub(t *testing.T) {
+	newUser := newUser2()
+	_ = newUser
+}
+`,
+			report: Report{Added: []string{"newUser2", "TestSub"}, Renamed: map[string]string{"newUser": "newUser2"}},
+		},
+		{
+			name:      "a renamed type keeps its methods",
+			generated: "type fakeStore struct{ n int }\n\nfunc (s *fakeStore) Get() int { return s.n }\n\nfunc TestSub(t *testing.T) {\n\t_ = &fakeStore{n: 2}\n}",
+			mode:      Append,
+			want: existingFile + `
+type fakeStore2 struct{ n int }
+
+func (s *fakeStore2) Get() int { return s.n }
+
+func TestSub(t *testing.T) {
+	_ = &fakeStore2{n: 2}
+}
+`,
+			report: Report{Added: []string{"fakeStore2", "fakeStore2.Get", "TestSub"}, Renamed: map[string]string{"fakeStore": "fakeStore2"}},
+		},
+		{
+			name:      "the helpers clashing with the same declaration are renamed apart",
+			generated: "var minID = 2\n\nvar maxID = 8\n\nfunc TestSub(t *testing.T) {\n\t_ = maxID - minID\n}",
+			mode:      Replace,
+			want: existingFile + `
+var minID2 = 2
+
+var maxID2 = 8
+
+func TestSub(t *testing.T) {
+	_ = maxID2 - minID2
+}
+`,
+			report: Report{Added: []string{"minID2", "maxID2", "TestSub"}, Renamed: map[string]string{"minID": "minID2", "maxID": "maxID2"}},
+		},
+		{
+			name:      "a different method is skipped",
+			generated: "func (s *fakeStore) Get() int { return 2 }",
+			mode:      Append,
+			want:      existingFile,
+			report:    Report{Skipped: []string{"fakeStore.Get"}},
+		},
+		{
+			name:      "the same test generated twice is kept once",
+			generated: "func TestSub(t *testing.T) {}\n\nfunc TestSub(t *testing.T) {}",
+			mode:      Append,
+			want:      existingFile + "\nfunc TestSub(t *testing.T) {}\n",
+			report:    Report{Added: []string{"TestSub"}},
+		},
+		{
+			name:      "the imports of the generated code are ignored",
+			generated: "package p\n\nimport \"fmt\"\n\nfunc TestSub(t *testing.T) { fmt.Println() }",
+			mode:      Append,
+			want:      existingFile + "\nfunc TestSub(t *testing.T) { fmt.Println() }\n",
+			report:    Report{Added: []string{"TestSub"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, report, err := Merge(existingFile, tt.generated, tt.mode)
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			if formatted(t, got) != formatted(t, tt.want) {
+				t.Errorf("Merge() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if !reflect.DeepEqual(*report, tt.report) {
+				t.Errorf("Merge() report = %+v, want %+v", *report, tt.report)
+			}
+		})
+	}
+}
+
+// formatted formats the code like goimports does after the merge
+func formatted(t *testing.T, code string) string {
+	t.Helper()
+	out, err := format.Source([]byte(code))
+	if err != nil {
+		t.Fatalf("failed to format:\n%s\n%v", code, err)
+	}
+	return string(out)
+}
+
+func TestMergeConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		generated string
+		mode      Mode
+	}{
+		{
+			name:      "new test declared twice",
+			generated: "func TestSub(t *testing.T) {}\n\nfunc TestSub(t *testing.T) { t.Fail() }",
+			mode:      Append,
+		},
+		{
+			name:      "existing test replaced twice",
+			generated: "func TestAdd(t *testing.T) {}\n\nfunc TestAdd(t *testing.T) { t.Fail() }",
+			mode:      Replace,
+		},
+		{
+			name:      "helper declared twice",
+			generated: "func helper() int { return 1 }\n\nfunc helper() int { return 2 }",
+			mode:      Append,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Merge(existingFile, tt.generated, tt.mode); !errors.Is(err, ErrConflict) {
+				t.Errorf("Merge() error = %v, want %v", err, ErrConflict)
+			}
+		})
+	}
+}
+
+func TestMergeInvalidCode(t *testing.T) {
+	if _, _, err := Merge("package p\n\nfunc {", "func TestX(t *testing.T) {}", Append); err == nil {
+		t.Error("Merge() of an invalid test file succeeded")
+	}
+	if _, _, err := Merge(existingFile, "func TestX(t *testing.T) {", Append); err == nil {
+		t.Error("Merge() of invalid generated code succeeded")
+	}
+}
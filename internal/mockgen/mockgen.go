@@ -0,0 +1,159 @@
+// Package mockgen generates testify/mock implementations of interfaces from their types.
+package mockgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// MockImport is the import path of the package the mocks embed
+const MockImport = "github.com/stretchr/testify/mock"
+
+// Mock is a testify mock of an interface
+type Mock struct {
+	Name      string   // Name of the mock type, e.g. mockStore
+	Interface string   // Interface qualified by its package name, e.g. repo.Store
+	Methods   []string // Signatures of the methods, e.g. Get(id int) (*repo.User, error)
+	Imports   []string // Import paths used by the code
+	Code      string
+}
+
+// reserved are the names used in the body of the mocked methods
+var reserved = map[string]bool{"m": true, "args": true, "v": true}
+
+// Generate generates the mock named name of the interface, for the test files of pkg
+func Generate(named *types.Named, name string, pkg *types.Package) (*Mock, error) {
+	obj := named.Obj()
+	iface, ok := named.Underlying().(*types.Interface)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an interface", obj.Name())
+	}
+	if named.TypeParams().Len() > 0 {
+		return nil, fmt.Errorf("generic interface %s is not supported", obj.Name())
+	}
+	if !iface.IsMethodSet() {
+		return nil, fmt.Errorf("%s is a type constraint", obj.Name())
+	}
+	if iface.NumMethods() == 0 {
+		return nil, fmt.Errorf("%s has no methods", obj.Name())
+	}
+
+	imports := map[string]bool{MockImport: true}
+	packageNames := make(map[string]bool)
+	qualifier := func(p *types.Package) string {
+		if p.Path() == pkg.Path() {
+			return ""
+		}
+		imports[p.Path()] = true
+		packageNames[p.Name()] = true
+		return p.Name()
+	}
+
+	mock := &Mock{Name: name, Interface: types.TypeString(named, qualifier)}
+	for i := 0; i < iface.NumMethods(); i++ {
+		method := iface.Method(i)
+		if !method.Exported() && method.Pkg().Path() != pkg.Path() {
+			return nil, fmt.Errorf("%s has the unexported method %s", mock.Interface, method.Name())
+		}
+	}
+
+	var code bytes.Buffer
+	fmt.Fprintf(&code, "// %s is a testify mock of %s\n", name, mock.Interface)
+	fmt.Fprintf(&code, "type %s struct {\n\tmock.Mock\n}\n", name)
+
+	for i := 0; i < iface.NumMethods(); i++ {
+		method := iface.Method(i)
+		sig := method.Type().(*types.Signature)
+		mock.Methods = append(mock.Methods, method.Name()+signature(sig, qualifier, nil))
+
+		// The parameters are renamed when they would shadow the names used in the body
+		names := make([]string, sig.Params().Len())
+		for j := range names {
+			paramName := sig.Params().At(j).Name()
+			if paramName == "" || paramName == "_" || reserved[paramName] || packageNames[paramName] {
+				paramName = fmt.Sprintf("arg%d", j)
+			}
+			names[j] = paramName
+		}
+
+		fmt.Fprintf(&code, "\nfunc (m *%s) %s%s {\n", name, method.Name(), signature(sig, qualifier, names))
+		called := "m.Called(" + strings.Join(names, ", ") + ")"
+		results := sig.Results()
+		if results.Len() == 0 {
+			fmt.Fprintf(&code, "\t%s\n}\n", called)
+			continue
+		}
+
+		fmt.Fprintf(&code, "\targs := %s\n", called)
+		returns := make([]string, results.Len())
+		for j := 0; j < results.Len(); j++ {
+			resultType := results.At(j).Type()
+			if isError(resultType) {
+				returns[j] = fmt.Sprintf("args.Error(%d)", j)
+				continue
+			}
+			typeName := types.TypeString(resultType, qualifier)
+			fmt.Fprintf(&code, "\tvar r%d %s\n\tif v := args.Get(%d); v != nil {\n\t\tr%d = v.(%s)\n\t}\n", j, typeName, j, j, typeName)
+			returns[j] = fmt.Sprintf("r%d", j)
+		}
+		fmt.Fprintf(&code, "\treturn %s\n}\n", strings.Join(returns, ", "))
+	}
+
+	formatted, err := format.Source(code.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format the mock of %s: %v", mock.Interface, err)
+	}
+	mock.Code = string(formatted)
+
+	for path := range imports {
+		mock.Imports = append(mock.Imports, path)
+	}
+	sort.Strings(mock.Imports)
+	return mock, nil
+}
+
+// signature formats the parameters and results of the signature, with the given parameter names,
+// or the declared ones if names is nil
+func signature(sig *types.Signature, qualifier types.Qualifier, names []string) string {
+	params := make([]string, sig.Params().Len())
+	for i := range params {
+		param := sig.Params().At(i)
+		typeName := types.TypeString(param.Type(), qualifier)
+		if sig.Variadic() && i == len(params)-1 {
+			typeName = "..." + types.TypeString(param.Type().(*types.Slice).Elem(), qualifier)
+		}
+
+		name := param.Name()
+		if names != nil {
+			name = names[i]
+		}
+		if name == "" {
+			params[i] = typeName
+		} else {
+			params[i] = name + " " + typeName
+		}
+	}
+
+	results := make([]string, sig.Results().Len())
+	for i := range results {
+		results[i] = types.TypeString(sig.Results().At(i).Type(), qualifier)
+	}
+
+	s := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return s
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
@@ -0,0 +1,261 @@
+package mockgen
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+const modelSource = `package model
+
+type User struct{ ID int }
+
+type Box[T any] struct{ V T }
+
+type Closer interface {
+	Close() error
+}
+
+type hidden interface {
+	secret()
+}
+
+type Secretive interface {
+	hidden
+	Name() string
+}
+`
+
+const repoSource = `package repo
+
+import (
+	"context"
+
+	"example.com/model"
+)
+
+type Reader interface {
+	Get(ctx context.Context, id int) (*model.User, error)
+}
+
+// Store embeds an interface of the package and one of another package
+type Store interface {
+	Reader
+	model.Closer
+	Put(ctx context.Context, user model.User) error
+}
+
+type Finder interface {
+	Find(ids ...int) ([]model.User, bool)
+	Log(format string, args ...interface{})
+}
+
+type Shadow interface {
+	Do(m int, args string, v bool, model string, _ int, x float64)
+	Unnamed(int, string) (int, string, error)
+}
+
+type Boxes interface {
+	Box(id int) model.Box[string]
+	Each(fn func(model.User) bool) map[string]chan<- *model.User
+}
+
+type Generic[T any] interface {
+	Get() T
+}
+
+type Number interface {
+	~int | ~float64
+}
+
+type Empty interface{}
+
+type NotInterface struct{}
+`
+
+// checkPackages type-checks the repo package and the model package it imports
+func checkPackages(t *testing.T) (*types.Package, *types.Package) {
+	t.Helper()
+	fset := token.NewFileSet()
+	check := func(path, src string, imp types.Importer) *types.Package {
+		file, err := parser.ParseFile(fset, path+".go", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := (&types.Config{Importer: imp}).Check(path, fset, []*ast.File{file}, nil)
+		if err != nil {
+			t.Fatalf("failed to type-check %s: %v", path, err)
+		}
+		return pkg
+	}
+
+	std := importer.ForCompiler(fset, "source", nil)
+	model := check("example.com/model", modelSource, std)
+	repo := check("example.com/repo", repoSource, importerFunc(func(path string) (*types.Package, error) {
+		if path == model.Path() {
+			return model, nil
+		}
+		return std.Import(path)
+	}))
+	return repo, model
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+func TestGenerate(t *testing.T) {
+	repo, model := checkPackages(t)
+	external := types.NewPackage("example.com/repo_test", "repo_test")
+
+	tests := []struct {
+		name        string
+		pkg         *types.Package // Package the interface is declared in
+		iface       string
+		testPackage *types.Package // Package of the test files, repo if nil
+		wantIface   string
+		wantMethods []string
+		wantImports []string
+		wantCode    []string // Parts of the code
+		wantErr     string
+	}{
+		{
+			name:      "method set with embedded interfaces",
+			pkg:       repo,
+			iface:     "Store",
+			wantIface: "Store",
+			wantMethods: []string{
+				"Close() error",
+				"Get(ctx context.Context, id int) (*model.User, error)",
+				"Put(ctx context.Context, user model.User) error",
+			},
+			wantImports: []string{"context", "example.com/model", MockImport},
+			wantCode: []string{
+				"// mockStore is a testify mock of Store\ntype mockStore struct {\n\tmock.Mock\n}",
+				"func (m *mockStore) Close() error {\n\targs := m.Called()\n\treturn args.Error(0)\n}",
+				"func (m *mockStore) Get(ctx context.Context, id int) (*model.User, error) {\n\targs := m.Called(ctx, id)\n" +
+					"\tvar r0 *model.User\n\tif v := args.Get(0); v != nil {\n\t\tr0 = v.(*model.User)\n\t}\n\treturn r0, args.Error(1)\n}",
+			},
+		},
+		{
+			name:        "variadic parameters",
+			pkg:         repo,
+			iface:       "Finder",
+			wantIface:   "Finder",
+			wantMethods: []string{"Find(ids ...int) ([]model.User, bool)", "Log(format string, args ...interface{})"},
+			wantImports: []string{"example.com/model", MockImport},
+			wantCode: []string{
+				"func (m *mockFinder) Find(ids ...int) ([]model.User, bool) {\n\targs := m.Called(ids)\n",
+				"func (m *mockFinder) Log(format string, arg1 ...interface{}) {\n\tm.Called(format, arg1)\n}",
+			},
+		},
+		{
+			name:        "parameters shadowing the names of the body",
+			pkg:         repo,
+			iface:       "Shadow",
+			wantIface:   "Shadow",
+			wantMethods: []string{"Do(m int, args string, v bool, model string, _ int, x float64)", "Unnamed(int, string) (int, string, error)"},
+			wantCode: []string{
+				"func (m *mockShadow) Do(arg0 int, arg1 string, arg2 bool, model string, arg4 int, x float64) {\n\tm.Called(arg0, arg1, arg2, model, arg4, x)\n}",
+				"func (m *mockShadow) Unnamed(arg0 int, arg1 string) (int, string, error) {",
+			},
+		},
+		{
+			name:        "instantiated generic types",
+			pkg:         repo,
+			iface:       "Boxes",
+			wantIface:   "Boxes",
+			wantMethods: []string{"Box(id int) model.Box[string]", "Each(fn func(model.User) bool) map[string]chan<- *model.User"},
+			wantCode: []string{
+				"\tvar r0 model.Box[string]\n\tif v := args.Get(0); v != nil {\n\t\tr0 = v.(model.Box[string])\n\t}",
+				"\tvar r0 map[string]chan<- *model.User\n",
+			},
+		},
+		{
+			name:        "external test package",
+			pkg:         repo,
+			iface:       "Reader",
+			testPackage: external,
+			wantIface:   "repo.Reader",
+			wantMethods: []string{"Get(ctx context.Context, id int) (*model.User, error)"},
+			wantImports: []string{"context", "example.com/model", "example.com/repo", MockImport},
+			wantCode:    []string{"// mockReader is a testify mock of repo.Reader\n"},
+		},
+		{
+			name:      "interface of another package",
+			pkg:       model,
+			iface:     "Closer",
+			wantIface: "model.Closer",
+			wantCode:  []string{"func (m *mockCloser) Close() error {"},
+		},
+		{
+			name:    "generic interface",
+			pkg:     repo,
+			iface:   "Generic",
+			wantErr: "generic interface Generic is not supported",
+		},
+		{
+			name:    "constraint",
+			pkg:     repo,
+			iface:   "Number",
+			wantErr: "Number is a type constraint",
+		},
+		{
+			name:    "no methods",
+			pkg:     repo,
+			iface:   "Empty",
+			wantErr: "Empty has no methods",
+		},
+		{
+			name:    "not an interface",
+			pkg:     repo,
+			iface:   "NotInterface",
+			wantErr: "NotInterface is not an interface",
+		},
+		{
+			name:    "unexported method of another package",
+			pkg:     model,
+			iface:   "Secretive",
+			wantErr: "model.Secretive has the unexported method secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			named := tt.pkg.Scope().Lookup(tt.iface).Type().(*types.Named)
+			testPackage := tt.testPackage
+			if testPackage == nil {
+				testPackage = repo
+			}
+			mock, err := Generate(named, "mock"+tt.iface, testPackage)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Generate() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			if mock.Name != "mock"+tt.iface || mock.Interface != tt.wantIface {
+				t.Errorf("Generate() = %s of %s, want mock%s of %s", mock.Name, mock.Interface, tt.iface, tt.wantIface)
+			}
+			if tt.wantMethods != nil && strings.Join(mock.Methods, "\n") != strings.Join(tt.wantMethods, "\n") {
+				t.Errorf("Generate() methods = %q, want %q", mock.Methods, tt.wantMethods)
+			}
+			if tt.wantImports != nil && strings.Join(mock.Imports, "\n") != strings.Join(tt.wantImports, "\n") {
+				t.Errorf("Generate() imports = %q, want %q", mock.Imports, tt.wantImports)
+			}
+			for _, part := range tt.wantCode {
+				if !strings.Contains(mock.Code, part) {
+					t.Errorf("Generate() code =\n%s\nwant it to contain\n%s", mock.Code, part)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"smart-testify/internal/provider"
+	"smart-testify/internal/sse"
+	"strings"
+)
+
+// ProviderName is the name the OpenAI compatible client is registered with
+const ProviderName = "openai"
+
+// DefaultBaseURL is used when no base URL is configured
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// Config holds the settings of an OpenAI compatible chat completions endpoint
+type Config struct {
+	BaseURL       string  `json:"base_url"`
+	APIKey        string  `json:"api_key"`
+	Model         string  `json:"model"`
+	Temperature   float64 `json:"temperature"`
+	MaxTokens     int     `json:"max_tokens"`
+	ContextTokens int     `json:"context_tokens"`
+	Stream        bool    `json:"stream"`
+}
+
+// Client talks to any endpoint implementing the OpenAI /v1/chat/completions protocol
+type Client struct {
+	Config     Config
+	HTTPClient *http.Client
+	OnToken    provider.TokenHandler // Called with each chunk of the completion while it is streamed
+}
+
+// NewClient initializes a Client instance
+func NewClient(config Config) *Client {
+	return &Client{
+		Config:     config,
+		HTTPClient: &http.Client{},
+	}
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Stream      bool      `json:"stream"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message message `json:"message"`
+		Delta   message `json:"delta"`
+	} `json:"choices"`
+	Error *apiError `json:"error"`
+}
+
+// Name returns the provider name of the client
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// Capabilities returns the features supported by the endpoint
+func (c *Client) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		Streaming: c.Config.Stream,
+	}
+}
+
+// Limits returns the token limits configured for the model
+func (c *Client) Limits() provider.Limits {
+	return provider.Limits{
+		Model:         c.Config.Model,
+		ContextTokens: c.Config.ContextTokens,
+		OutputTokens:  c.Config.MaxTokens,
+	}
+}
+
+// SetTokenHandler sets the handler called with each chunk of the streamed completion
+func (c *Client) SetTokenHandler(handler provider.TokenHandler) {
+	c.OnToken = handler
+}
+
+// Chat sends the prompt to the chat completions endpoint and returns the completion
+func (c *Client) Chat(ctx context.Context, prompt string) (string, error) {
+	if c.Config.Model == "" {
+		return "", errors.New("model is not configured, please run 'smart-testify config openai set --model <name>' to set it")
+	}
+
+	reqBody, err := json.Marshal(chatRequest{
+		Model:       c.Config.Model,
+		Messages:    []message{{Role: "user", Content: prompt}},
+		Temperature: c.Config.Temperature,
+		MaxTokens:   c.Config.MaxTokens,
+		Stream:      c.Config.Stream,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.chatURL(), bytes.NewReader(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Config.APIKey)
+	}
+	if c.Config.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", provider.NewHTTPError(resp, body)
+	}
+
+	if c.Config.Stream {
+		return c.readStream(resp.Body)
+	}
+	return readResponse(resp.Body)
+}
+
+func (c *Client) chatURL() string {
+	baseURL := c.Config.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/chat/completions"
+}
+
+// readResponse parses a non-streaming chat completion
+func readResponse(body io.Reader) (string, error) {
+	respBody, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var chatResp chatResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response body: %v", err)
+	}
+	if chatResp.Error != nil {
+		return "", fmt.Errorf("request failed: %s", chatResp.Error.Message)
+	}
+	if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("no response received, response body: %s", string(respBody))
+	}
+
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// readStream parses a streaming chat completion sent as server-sent events
+func (c *Client) readStream(body io.Reader) (string, error) {
+	var result strings.Builder
+	reader := sse.NewReader(body)
+
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read event stream: %v", err)
+		}
+		if event.Data == "[DONE]" {
+			break
+		}
+
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			return "", fmt.Errorf("failed to unmarshal event %q: %v", event.Data, err)
+		}
+		if chunk.Error != nil {
+			return "", fmt.Errorf("request failed: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			result.WriteString(chunk.Choices[0].Delta.Content)
+			if c.OnToken != nil {
+				c.OnToken(chunk.Choices[0].Delta.Content)
+			}
+		}
+	}
+
+	if result.Len() == 0 {
+		return "", errors.New("no response received from the event stream")
+	}
+	return result.String(), nil
+}
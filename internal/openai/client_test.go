@@ -0,0 +1,162 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"smart-testify/internal/provider"
+	"strings"
+	"testing"
+)
+
+func TestClientChat(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     bool
+		model      string
+		status     int
+		response   string
+		want       string
+		wantTokens []string
+		wantErr    string
+		wantCode   int // Status of the HTTPError expected, 0 if none
+	}{
+		{
+			name:     "completion",
+			status:   http.StatusOK,
+			response: `{"choices":[{"message":{"role":"assistant","content":"func TestX(t *testing.T) {}"}}]}`,
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "error in the body",
+			status:   http.StatusOK,
+			response: `{"error":{"message":"model overloaded","type":"server_error"}}`,
+			wantErr:  "request failed: model overloaded",
+		},
+		{
+			name:     "empty choices",
+			status:   http.StatusOK,
+			response: `{"choices":[]}`,
+			wantErr:  "no response received",
+		},
+		{
+			name:     "empty content",
+			status:   http.StatusOK,
+			response: `{"choices":[{"message":{"role":"assistant","content":""}}]}`,
+			wantErr:  "no response received",
+		},
+		{
+			name:     "invalid body",
+			status:   http.StatusOK,
+			response: `not json`,
+			wantErr:  "failed to unmarshal response body",
+		},
+		{
+			name:     "error status",
+			status:   http.StatusTooManyRequests,
+			response: `{"error":{"message":"rate limited"}}`,
+			wantErr:  "rate limited",
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name:    "without model",
+			model:   "-",
+			wantErr: "model is not configured",
+		},
+		{
+			name:   "stream",
+			stream: true,
+			status: http.StatusOK,
+			response: "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"func \"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"TestX\"}}]}\n\n" +
+				"data: [DONE]\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"ignored\"}}]}\n\n",
+			want:       "func TestX",
+			wantTokens: []string{"func ", "TestX"},
+		},
+		{
+			name:     "stream error event",
+			stream:   true,
+			status:   http.StatusOK,
+			response: "data: {\"choices\":[{\"delta\":{\"content\":\"func\"}}]}\n\ndata: {\"error\":{\"message\":\"context length exceeded\"}}\n\n",
+			wantErr:  "request failed: context length exceeded",
+		},
+		{
+			name:     "stream invalid event",
+			stream:   true,
+			status:   http.StatusOK,
+			response: "data: {oops\n\n",
+			wantErr:  "failed to unmarshal event",
+		},
+		{
+			name:     "empty stream",
+			stream:   true,
+			status:   http.StatusOK,
+			response: "data: [DONE]\n\n",
+			wantErr:  "no response received from the event stream",
+		},
+		{
+			name:     "stream error status",
+			stream:   true,
+			status:   http.StatusUnauthorized,
+			response: `{"error":{"message":"invalid api key"}}`,
+			wantErr:  "invalid api key",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got chatRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+					t.Errorf("Authorization = %q, want the bearer API key", auth)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("failed to decode the request: %v", err)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			model := "gpt-4o"
+			if tt.model == "-" {
+				model = ""
+			}
+			client := NewClient(Config{BaseURL: server.URL + "/v1/", APIKey: "key", Model: model, Stream: tt.stream})
+			var tokens []string
+			client.SetTokenHandler(func(token string) { tokens = append(tokens, token) })
+
+			resp, err := client.Chat(context.Background(), "prompt")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Chat() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				var httpErr *provider.HTTPError
+				if tt.wantCode != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantCode) {
+					t.Errorf("Chat() error = %v, want an HTTPError with status %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if resp != tt.want {
+				t.Errorf("Chat() = %q, want %q", resp, tt.want)
+			}
+			if strings.Join(tokens, "|") != strings.Join(tt.wantTokens, "|") {
+				t.Errorf("streamed tokens = %q, want %q", tokens, tt.wantTokens)
+			}
+			if got.Model != model || got.Stream != tt.stream || len(got.Messages) != 1 || got.Messages[0].Content != "prompt" {
+				t.Errorf("request = %+v, want the model and the prompt as a single message", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ReplayProviderName is the name reported by the replay provider
+const ReplayProviderName = "replay"
+
+// Interaction is a recorded prompt and the response returned for it
+type Interaction struct {
+	Provider   string    `json:"provider"`
+	Prompt     string    `json:"prompt"`
+	Response   string    `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Cassette stores interactions keyed by the hash of their prompt
+type Cassette struct {
+	Limits       Limits                 `json:"limits"` // Limits of the recorded provider, they shape the prompts
+	Interactions map[string]Interaction `json:"interactions"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// HashPrompt returns the key used to store the prompt in a cassette
+func HashPrompt(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadCassette reads the cassette at path, a missing file results in an empty cassette
+func LoadCassette(path string) (*Cassette, error) {
+	cassette := &Cassette{
+		Interactions: make(map[string]Interaction),
+		path:         path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cassette, nil
+		}
+		return nil, fmt.Errorf("failed to read cassette file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cassette file %s: %v", path, err)
+	}
+	if cassette.Interactions == nil {
+		cassette.Interactions = make(map[string]Interaction)
+	}
+	return cassette, nil
+}
+
+// Path returns the file the cassette is stored in
+func (c *Cassette) Path() string {
+	return c.path
+}
+
+// Len returns the number of recorded interactions
+func (c *Cassette) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.Interactions)
+}
+
+// Get returns the interaction recorded for the prompt
+func (c *Cassette) Get(prompt string) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interaction, ok := c.Interactions[HashPrompt(prompt)]
+	return interaction, ok
+}
+
+// Put records the interaction and writes the cassette to disk, so an interrupted run keeps what it recorded
+func (c *Cassette) Put(interaction Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Interactions[HashPrompt(interaction.Prompt)] = interaction
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %v", err)
+	}
+	if err := ioutil.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette file: %v", err)
+	}
+	return nil
+}
+
+// Recorder wraps a provider and records every successful call in a cassette
+type Recorder struct {
+	Provider
+	cassette *Cassette
+}
+
+// NewRecorder creates a Recorder around the provider
+func NewRecorder(p Provider, cassette *Cassette) *Recorder {
+	cassette.mu.Lock()
+	cassette.Limits = p.Limits()
+	cassette.mu.Unlock()
+
+	return &Recorder{
+		Provider: p,
+		cassette: cassette,
+	}
+}
+
+// SetTokenHandler forwards the handler to the wrapped provider if it supports streaming
+func (r *Recorder) SetTokenHandler(handler TokenHandler) {
+	if streamer, ok := r.Provider.(Streamer); ok {
+		streamer.SetTokenHandler(handler)
+	}
+}
+
+// Chat calls the wrapped provider and records the response
+func (r *Recorder) Chat(ctx context.Context, prompt string) (string, error) {
+	resp, err := r.Provider.Chat(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.cassette.Put(Interaction{
+		Provider:   r.Provider.Name(),
+		Prompt:     prompt,
+		Response:   resp,
+		RecordedAt: time.Now().UTC(),
+	}); err != nil {
+		return "", fmt.Errorf("failed to record response: %v", err)
+	}
+	return resp, nil
+}
+
+// Replayer serves responses from a cassette without calling any model
+type Replayer struct {
+	cassette *Cassette
+}
+
+// NewReplayer creates a Replayer on top of the cassette
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{cassette: cassette}
+}
+
+// Name returns the provider name of the replayer
+func (r *Replayer) Name() string {
+	return ReplayProviderName
+}
+
+// Chat returns the recorded response, it fails when the prompt was never recorded
+func (r *Replayer) Chat(ctx context.Context, prompt string) (string, error) {
+	interaction, ok := r.cassette.Get(prompt)
+	if !ok {
+		return "", fmt.Errorf("cassette miss: no response recorded for prompt hash %s in %s, the prompt has changed since it was recorded", HashPrompt(prompt), r.cassette.Path())
+	}
+	return interaction.Response, nil
+}
+
+// Capabilities returns the features of the replayer
+func (r *Replayer) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// Limits returns the limits of the recorded provider, so the prompts are assembled the same way
+func (r *Replayer) Limits() Limits {
+	return r.cassette.Limits
+}
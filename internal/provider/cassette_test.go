@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeProvider answers each prompt with a fixed response
+type fakeProvider struct {
+	responses map[string]string
+	calls     int
+}
+
+func (f *fakeProvider) Name() string { return "fake" }
+
+func (f *fakeProvider) Chat(ctx context.Context, prompt string) (string, error) {
+	f.calls++
+	resp, ok := f.responses[prompt]
+	if !ok {
+		return "", errors.New("unexpected prompt")
+	}
+	return resp, nil
+}
+
+func (f *fakeProvider) Capabilities() Capabilities { return Capabilities{} }
+
+func (f *fakeProvider) Limits() Limits { return Limits{Model: "fake-model", ContextTokens: 1000} }
+
+func TestReplayer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() of a missing file error = %v", err)
+	}
+
+	fake := &fakeProvider{responses: map[string]string{"prompt A": "response A", "prompt B": "response B"}}
+	recorder := NewRecorder(fake, cassette)
+	for _, prompt := range []string{"prompt A", "prompt B"} {
+		if _, err := recorder.Chat(context.Background(), prompt); err != nil {
+			t.Fatalf("Recorder.Chat(%q) error = %v", prompt, err)
+		}
+	}
+	if _, err := recorder.Chat(context.Background(), "prompt C"); err == nil {
+		t.Fatal("Recorder.Chat() of a failing call succeeded")
+	}
+
+	// The replayer reads the cassette back from disk
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() error = %v", err)
+	}
+	if loaded.Len() != 2 {
+		t.Errorf("cassette has %d interactions, want 2, the failed call must not be recorded", loaded.Len())
+	}
+	replayer := NewReplayer(loaded)
+	if limits := replayer.Limits(); limits != fake.Limits() {
+		t.Errorf("Replayer.Limits() = %+v, want the recorded %+v", limits, fake.Limits())
+	}
+
+	tests := []struct {
+		name    string
+		prompt  string
+		want    string
+		wantErr string
+	}{
+		{name: "hit", prompt: "prompt A", want: "response A"},
+		{name: "other hit", prompt: "prompt B", want: "response B"},
+		{name: "miss", prompt: "prompt C", wantErr: "cassette miss: no response recorded for prompt hash " + HashPrompt("prompt C")},
+		{name: "a changed prompt misses", prompt: "prompt A ", wantErr: "cassette miss"},
+	}
+	calls := fake.calls
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replayer.Chat(context.Background(), tt.prompt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Replayer.Chat() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Replayer.Chat() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Replayer.Chat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if fake.calls != calls {
+		t.Errorf("the replayer called the provider %d times", fake.calls-calls)
+	}
+}
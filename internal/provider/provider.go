@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"smart-testify/internal/logger"
+	"sort"
+	"sync"
+)
+
+var log = logger.GetLogger() // Global logger
+
+// Capabilities describes optional features supported by a provider
+type Capabilities struct {
+	Streaming  bool // Whether the response can be streamed token by token
+	Contextual bool // Whether previous messages are kept between calls
+}
+
+// Limits describes the token limits of the model behind a provider, 0 means unknown
+type Limits struct {
+	Model         string // Name of the model, used to estimate the number of tokens
+	ContextTokens int    // Maximum number of tokens accepted in the prompt
+	OutputTokens  int    // Maximum number of tokens returned in the completion
+}
+
+// Provider is implemented by every LLM backend used to generate test cases
+type Provider interface {
+	// Name returns the name the provider is registered with
+	Name() string
+	// Chat sends the prompt to the model and returns the completion, the request is cancelled with the context
+	Chat(ctx context.Context, prompt string) (string, error)
+	Capabilities() Capabilities
+	Limits() Limits
+}
+
+// TokenHandler is called with each chunk of a completion as soon as it arrives
+type TokenHandler func(token string)
+
+// Streamer is implemented by providers that can report a streamed completion while it arrives
+type Streamer interface {
+	SetTokenHandler(handler TokenHandler)
+}
+
+// Factory creates a provider, it is called lazily when the provider is first used
+type Factory func() (Provider, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a provider available by the given name. It panics if the name is registered twice.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("provider: Register factory is nil for " + name)
+	}
+	if _, exists := factories[name]; exists {
+		panic("provider: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// IsRegistered reports whether a provider with the given name exists
+func IsRegistered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	_, ok := factories[name]
+	return ok
+}
+
+// Names returns the sorted names of all registered providers
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the provider registered with the given name
+func New(name string) (Provider, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s, must be one of %v", name, Names())
+	}
+	return factory()
+}
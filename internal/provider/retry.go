@@ -0,0 +1,239 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPError is returned by providers when the server answers with an unexpected status code
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration // Parsed from the Retry-After header, 0 if absent
+}
+
+// NewHTTPError creates an HTTPError from the response and its body
+func NewHTTPError(resp *http.Response, body []byte) *HTTPError {
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("request failed with status: %s, body: %s", e.Status, e.Body)
+}
+
+// parseRetryAfter parses the header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// ErrTimeout is returned when a call takes longer than the configured timeout
+var ErrTimeout = errors.New("request timed out")
+
+// RetryConfig controls how failed calls are retried
+type RetryConfig struct {
+	MaxAttempts int           // Total number of attempts, including the first one
+	BaseDelay   time.Duration // Delay before the first retry, doubled on each retry
+	MaxDelay    time.Duration // Upper bound of the delay, also applied to Retry-After
+	Timeout     time.Duration // Timeout of each attempt, 0 means no timeout
+}
+
+// RetryStats summarizes the calls made through a Retrier
+type RetryStats struct {
+	Calls    int            // Number of Chat calls
+	Attempts int            // Number of attempts, including retries
+	Retries  int            // Number of retried attempts
+	Failures int            // Number of calls that failed after all attempts
+	Waited   time.Duration  // Total time spent waiting between attempts
+	Reasons  map[string]int // Number of retries per reason, e.g. "429" or "timeout"
+}
+
+// Retrier wraps a provider and retries calls failing with transient errors,
+// using exponential backoff with jitter and honoring Retry-After
+type Retrier struct {
+	Provider
+	config RetryConfig
+	sleep  func(ctx context.Context, d time.Duration) error
+
+	mu    sync.Mutex
+	stats RetryStats
+}
+
+// NewRetrier creates a Retrier around the provider
+func NewRetrier(p Provider, config RetryConfig) *Retrier {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = time.Second
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = time.Minute
+	}
+
+	return &Retrier{
+		Provider: p,
+		config:   config,
+		sleep:    sleepContext,
+		stats:    RetryStats{Reasons: make(map[string]int)},
+	}
+}
+
+// SetTokenHandler forwards the handler to the wrapped provider if it supports streaming
+func (r *Retrier) SetTokenHandler(handler TokenHandler) {
+	if streamer, ok := r.Provider.(Streamer); ok {
+		streamer.SetTokenHandler(handler)
+	}
+}
+
+// Stats returns a snapshot of the retry statistics
+func (r *Retrier) Stats() RetryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Reasons = make(map[string]int, len(r.stats.Reasons))
+	for reason, count := range r.stats.Reasons {
+		stats.Reasons[reason] = count
+	}
+	return stats
+}
+
+// Chat calls the wrapped provider, retrying transient failures up to MaxAttempts times until the context is cancelled
+func (r *Retrier) Chat(ctx context.Context, prompt string) (string, error) {
+	r.record(func(stats *RetryStats) { stats.Calls++ })
+
+	var lastErr error
+	for attempt := 1; attempt <= r.config.MaxAttempts; attempt++ {
+		r.record(func(stats *RetryStats) { stats.Attempts++ })
+
+		resp, err := r.chatWithTimeout(ctx, prompt)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			lastErr = ctx.Err()
+			break
+		}
+
+		reason, retryable := retryReason(err)
+		if !retryable || attempt == r.config.MaxAttempts {
+			break
+		}
+
+		delay := r.backoff(attempt, err)
+		log.Warnf("%s call failed (%s), retrying in %v, attempt %d of %d: %v", r.Name(), reason, delay, attempt+1, r.config.MaxAttempts, err)
+		r.record(func(stats *RetryStats) {
+			stats.Retries++
+			stats.Waited += delay
+			stats.Reasons[reason]++
+		})
+		if err := r.sleep(ctx, delay); err != nil {
+			lastErr = err
+			break
+		}
+	}
+
+	r.record(func(stats *RetryStats) { stats.Failures++ })
+	return "", lastErr
+}
+
+// chatWithTimeout runs the call, cancelling its request after the timeout
+func (r *Retrier) chatWithTimeout(ctx context.Context, prompt string) (string, error) {
+	if r.config.Timeout <= 0 {
+		return r.Provider.Chat(ctx, prompt)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	resp, err := r.Provider.Chat(attemptCtx, prompt)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("%w after %v", ErrTimeout, r.config.Timeout)
+	}
+	return resp, err
+}
+
+// sleepContext waits for the delay, or returns the context error as soon as it is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the delay before the next attempt
+func (r *Retrier) backoff(attempt int, err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		if httpErr.RetryAfter > r.config.MaxDelay {
+			return r.config.MaxDelay
+		}
+		return httpErr.RetryAfter
+	}
+
+	delay := r.config.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > r.config.MaxDelay {
+		delay = r.config.MaxDelay
+	}
+	// Equal jitter: wait between half and the full delay
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (r *Retrier) record(update func(stats *RetryStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	update(&r.stats)
+}
+
+// retryReason reports whether the error is transient, and a short reason used in the statistics
+func retryReason(err error) (string, bool) {
+	if errors.Is(err, ErrTimeout) {
+		return "timeout", true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500 {
+			return strconv.Itoa(httpErr.StatusCode), true
+		}
+		return strconv.Itoa(httpErr.StatusCode), false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "network", true
+	}
+	return "", false
+}
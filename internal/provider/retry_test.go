@@ -0,0 +1,240 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyProvider fails with the errors in order, then answers "ok". A nil error blocks the attempt
+// until its context is done, like a request which hangs.
+type flakyProvider struct {
+	fakeProvider
+	errs []error
+}
+
+func (f *flakyProvider) Chat(ctx context.Context, prompt string) (string, error) {
+	f.calls++
+	if f.calls > len(f.errs) {
+		return "ok", nil
+	}
+	if err := f.errs[f.calls-1]; err != nil {
+		return "", err
+	}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// netTimeout is a net.Error such as a dial timeout
+type netTimeout struct{}
+
+func (netTimeout) Error() string   { return "dial tcp: i/o timeout" }
+func (netTimeout) Timeout() bool   { return true }
+func (netTimeout) Temporary() bool { return true }
+
+func TestRetrierChat(t *testing.T) {
+	status := func(code int) error {
+		return &HTTPError{StatusCode: code, Status: http.StatusText(code)}
+	}
+	config := RetryConfig{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		name      string
+		config    RetryConfig
+		errs      []error
+		want      string
+		wantErr   string
+		wantCalls int
+		wantStats RetryStats
+	}{
+		{
+			name:      "success",
+			config:    config,
+			want:      "ok",
+			wantCalls: 1,
+			wantStats: RetryStats{Calls: 1, Attempts: 1, Reasons: map[string]int{}},
+		},
+		{
+			name:      "transient failures are retried",
+			config:    config,
+			errs:      []error{status(http.StatusTooManyRequests), status(http.StatusBadGateway)},
+			want:      "ok",
+			wantCalls: 3,
+			wantStats: RetryStats{Calls: 1, Attempts: 3, Retries: 2, Reasons: map[string]int{"429": 1, "502": 1}},
+		},
+		{
+			name:      "network errors are retried",
+			config:    config,
+			errs:      []error{netTimeout{}},
+			want:      "ok",
+			wantCalls: 2,
+			wantStats: RetryStats{Calls: 1, Attempts: 2, Retries: 1, Reasons: map[string]int{"network": 1}},
+		},
+		{
+			name:      "client errors are not retried",
+			config:    config,
+			errs:      []error{status(http.StatusBadRequest)},
+			wantErr:   "Bad Request",
+			wantCalls: 1,
+			wantStats: RetryStats{Calls: 1, Attempts: 1, Failures: 1, Reasons: map[string]int{}},
+		},
+		{
+			name:      "unauthorized is not retried",
+			config:    config,
+			errs:      []error{status(http.StatusUnauthorized)},
+			wantErr:   "Unauthorized",
+			wantCalls: 1,
+			wantStats: RetryStats{Calls: 1, Attempts: 1, Failures: 1, Reasons: map[string]int{}},
+		},
+		{
+			name:      "other errors are not retried",
+			config:    config,
+			errs:      []error{errors.New("no response received")},
+			wantErr:   "no response received",
+			wantCalls: 1,
+			wantStats: RetryStats{Calls: 1, Attempts: 1, Failures: 1, Reasons: map[string]int{}},
+		},
+		{
+			name:      "gives up after the attempts",
+			config:    config,
+			errs:      []error{status(500), status(500), status(500), status(500)},
+			wantErr:   "Internal Server Error",
+			wantCalls: 3,
+			wantStats: RetryStats{Calls: 1, Attempts: 3, Retries: 2, Failures: 1, Reasons: map[string]int{"500": 2}},
+		},
+		{
+			name:      "a hanging attempt times out and is retried",
+			config:    RetryConfig{MaxAttempts: 2, Timeout: 10 * time.Millisecond},
+			errs:      []error{nil},
+			want:      "ok",
+			wantCalls: 2,
+			wantStats: RetryStats{Calls: 1, Attempts: 2, Retries: 1, Reasons: map[string]int{"timeout": 1}},
+		},
+		{
+			name:      "the last timeout is reported",
+			config:    RetryConfig{MaxAttempts: 1, Timeout: 10 * time.Millisecond},
+			errs:      []error{nil},
+			wantErr:   "request timed out after 10ms",
+			wantCalls: 1,
+			wantStats: RetryStats{Calls: 1, Attempts: 1, Failures: 1, Reasons: map[string]int{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &flakyProvider{errs: tt.errs}
+			retrier := NewRetrier(fake, tt.config)
+			var waited time.Duration
+			retrier.sleep = func(ctx context.Context, d time.Duration) error {
+				waited += d
+				return nil
+			}
+
+			got, err := retrier.Chat(context.Background(), "prompt")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Chat() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil || got != tt.want {
+				t.Fatalf("Chat() = %q, %v, want %q", got, err, tt.want)
+			}
+			if fake.calls != tt.wantCalls {
+				t.Errorf("Chat() called the provider %d times, want %d", fake.calls, tt.wantCalls)
+			}
+
+			stats := retrier.Stats()
+			if stats.Waited != waited {
+				t.Errorf("Stats().Waited = %v, want the %v slept", stats.Waited, waited)
+			}
+			stats.Waited = 0
+			if !reflect.DeepEqual(stats, tt.wantStats) {
+				t.Errorf("Stats() = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	retrier := NewRetrier(&fakeProvider{}, RetryConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{name: "first retry", attempt: 1, err: errors.New("x"), min: 500 * time.Millisecond, max: time.Second},
+		{name: "doubled", attempt: 2, err: errors.New("x"), min: time.Second, max: 2 * time.Second},
+		{name: "doubled again", attempt: 3, err: errors.New("x"), min: 2 * time.Second, max: 4 * time.Second},
+		{name: "capped", attempt: 5, err: errors.New("x"), min: 5 * time.Second, max: 10 * time.Second},
+		{name: "no overflow", attempt: 80, err: errors.New("x"), min: 5 * time.Second, max: 10 * time.Second},
+		{name: "Retry-After", attempt: 1, err: &HTTPError{StatusCode: 429, RetryAfter: 7 * time.Second}, min: 7 * time.Second, max: 7 * time.Second},
+		{name: "Retry-After capped", attempt: 1, err: &HTTPError{StatusCode: 503, RetryAfter: time.Hour}, min: 10 * time.Second, max: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The jitter is random, so check the bounds over several draws
+			for i := 0; i < 100; i++ {
+				if got := retrier.backoff(tt.attempt, tt.err); got < tt.min || got > tt.max {
+					t.Fatalf("backoff() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("parseRetryAfter(3) = %v, want 3s", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter() of a date in a minute = %v", got)
+	}
+	for _, value := range []string{"", "0", "-1", "soon", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %v, want 0", value, got)
+		}
+	}
+}
+
+func TestRetrierCancelled(t *testing.T) {
+	t.Run("during the backoff", func(t *testing.T) {
+		fake := &flakyProvider{errs: []error{&HTTPError{StatusCode: 503}}}
+		retrier := NewRetrier(fake, RetryConfig{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+		ctx, cancel := context.WithCancel(context.Background())
+		retrier.sleep = func(sleepCtx context.Context, d time.Duration) error {
+			cancel()
+			return sleepContext(sleepCtx, d)
+		}
+
+		if _, err := retrier.Chat(ctx, "prompt"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Chat() error = %v, want %v", err, context.Canceled)
+		}
+		if fake.calls != 1 {
+			t.Errorf("Chat() called the provider %d times after the cancellation, want 1", fake.calls)
+		}
+	})
+
+	t.Run("during an attempt", func(t *testing.T) {
+		fake := &flakyProvider{errs: []error{nil}}
+		retrier := NewRetrier(fake, RetryConfig{MaxAttempts: 3, Timeout: time.Hour})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := retrier.Chat(ctx, "prompt")
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) {
+			t.Fatalf("Chat() error = %v, want the context error rather than %v", err, ErrTimeout)
+		}
+		if fake.calls != 1 {
+			t.Errorf("Chat() retried %d times after the cancellation", fake.calls-1)
+		}
+		if stats := retrier.Stats(); stats.Retries != 0 || stats.Failures != 1 {
+			t.Errorf("Stats() = %+v, want a failure without retries", stats)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxLineSize is the largest single line accepted in the stream, completions can be big
+const maxLineSize = 1024 * 1024
+
+// Event is a single server-sent event
+type Event struct {
+	Name string // Value of the "event:" field, empty for the default "message" event
+	Data string // Value of the "data:" fields, joined with newlines
+}
+
+// Reader parses a text/event-stream body incrementally
+type Reader struct {
+	scanner *bufio.Scanner
+}
+
+// NewReader creates a Reader on top of r
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next event in the stream, or io.EOF when the stream is finished
+func (r *Reader) Next() (*Event, error) {
+	var event Event
+	var data []string
+	hasData := false
+
+	for r.scanner.Scan() {
+		line := strings.TrimSuffix(r.scanner.Text(), "\r")
+
+		// A blank line dispatches the event
+		if line == "" {
+			if !hasData && event.Name == "" {
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return &event, nil
+		}
+
+		// Lines starting with a colon are comments, e.g. keep-alive pings
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			event.Name = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The stream may end without a trailing blank line
+	if hasData || event.Name != "" {
+		event.Data = strings.Join(data, "\n")
+		return &event, nil
+	}
+	return nil, io.EOF
+}
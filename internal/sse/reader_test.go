@@ -0,0 +1,104 @@
+package sse
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReaderNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []Event
+	}{
+		{
+			name:   "events",
+			stream: "data: one\n\ndata: two\n\n",
+			want:   []Event{{Data: "one"}, {Data: "two"}},
+		},
+		{
+			name:   "done",
+			stream: "data: {\"a\":1}\n\ndata: [DONE]\n\n",
+			want:   []Event{{Data: `{"a":1}`}, {Data: "[DONE]"}},
+		},
+		{
+			name:   "named error event",
+			stream: "event: error\ndata: {\"message\":\"overloaded\"}\n\n",
+			want:   []Event{{Name: "error", Data: `{"message":"overloaded"}`}},
+		},
+		{
+			name:   "comments are skipped",
+			stream: ": keep-alive\n\n:ping\ndata: one\n: inside\n\n",
+			want:   []Event{{Data: "one"}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: first\ndata: second\ndata:third\n\n",
+			want:   []Event{{Data: "first\nsecond\nthird"}},
+		},
+		{
+			name:   "final event without a trailing blank line",
+			stream: "data: one\n\ndata: last",
+			want:   []Event{{Data: "one"}, {Data: "last"}},
+		},
+		{
+			name:   "CRLF line endings",
+			stream: "event: message\r\ndata: one\r\n\r\n",
+			want:   []Event{{Name: "message", Data: "one"}},
+		},
+		{
+			name:   "empty data field",
+			stream: "data\n\n",
+			want:   []Event{{Data: ""}},
+		},
+		{
+			name:   "unknown fields are ignored",
+			stream: "id: 7\nretry: 1000\ndata: one\n\n",
+			want:   []Event{{Data: "one"}},
+		},
+		{
+			name:   "blank lines only",
+			stream: "\n\n\n",
+		},
+		{
+			name: "empty stream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewReader(strings.NewReader(tt.stream))
+			var got []Event
+			for {
+				event, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next() error = %v", err)
+				}
+				got = append(got, *event)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Next() events = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderLongLine(t *testing.T) {
+	data := strings.Repeat("x", 200*1024)
+	event, err := NewReader(strings.NewReader("data: " + data + "\n\n")).Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if event.Data != data {
+		t.Errorf("Next() data has %d bytes, want %d", len(event.Data), len(data))
+	}
+
+	if _, err := NewReader(strings.NewReader("data: " + strings.Repeat("x", maxLineSize+1) + "\n\n")).Next(); err == nil || err == io.EOF {
+		t.Errorf("Next() of a line over the limit error = %v, want a scanner error", err)
+	}
+}
@@ -2,12 +2,31 @@ package twinkle
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"smart-testify/internal/provider"
+	"time"
 )
 
+// ProviderName is the name the Twinkle client is registered with
+const ProviderName = "twinkle"
+
+// defaultTimeout is used when no timeout is configured
+const defaultTimeout = 120 * time.Second
+
+// Config holds the settings of the Twinkle endpoint
+type Config struct {
+	Endpoint       string            `json:"endpoint"`
+	AuthHeader     string            `json:"auth_header"` // Header used to send the token, defaults to Authorization
+	AuthToken      string            `json:"auth_token"`  // Sent verbatim, include the scheme if needed, e.g. "Bearer xxx"
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	Headers        map[string]string `json:"headers"`
+}
+
 type TwinkleRequest struct {
 	Prompt string `json:"prompt"`
 }
@@ -16,9 +35,46 @@ type TwinkleResponse struct {
 	Completion string `json:"completion"`
 }
 
-func CallTwinkleAPI(prompt string) (string, error) {
-	url := "xx" // TODO replace with the actual URL from config
+// Client implements provider.Provider on top of the Twinkle API
+type Client struct {
+	Config     Config
+	HTTPClient *http.Client
+}
+
+// NewClient initializes a Twinkle client, it fails if no endpoint is configured
+func NewClient(config Config) (*Client, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("twinkle endpoint is not configured, please run 'smart-testify config twinkle set-endpoint <url>' to set it")
+	}
+
+	timeout := defaultTimeout
+	if config.TimeoutSeconds > 0 {
+		timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+
+	return &Client{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name returns the provider name of the client
+func (c *Client) Name() string {
+	return ProviderName
+}
+
+// Capabilities returns the features supported by Twinkle
+func (c *Client) Capabilities() provider.Capabilities {
+	return provider.Capabilities{}
+}
+
+// Limits returns the token limits of Twinkle, which are unknown
+func (c *Client) Limits() provider.Limits {
+	return provider.Limits{}
+}
 
+// Chat sends the prompt to Twinkle and returns the completion
+func (c *Client) Chat(ctx context.Context, prompt string) (string, error) {
 	// 创建请求体
 	requestBody, err := json.Marshal(TwinkleRequest{
 		Prompt: prompt,
@@ -28,17 +84,26 @@ func CallTwinkleAPI(prompt string) (string, error) {
 	}
 
 	// 创建 HTTP 请求
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Config.Endpoint, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	for name, value := range c.Config.Headers {
+		req.Header.Set(name, value)
+	}
+	if c.Config.AuthToken != "" {
+		authHeader := c.Config.AuthHeader
+		if authHeader == "" {
+			authHeader = "Authorization"
+		}
+		req.Header.Set(authHeader, c.Config.AuthToken)
+	}
 
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -49,7 +114,7 @@ func CallTwinkleAPI(prompt string) (string, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("request failed with status: %s, body: %s", resp.Status, string(body))
+		return "", provider.NewHTTPError(resp, body)
 	}
 
 	// 解析响应
@@ -57,6 +122,9 @@ func CallTwinkleAPI(prompt string) (string, error) {
 	if err := json.Unmarshal(body, &twinkleResponse); err != nil {
 		return "", fmt.Errorf("failed to unmarshal response body: %v", err)
 	}
+	if twinkleResponse.Completion == "" {
+		return "", fmt.Errorf("no response received, response body: %s", string(body))
+	}
 
 	return twinkleResponse.Completion, nil
 }
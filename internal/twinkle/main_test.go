@@ -0,0 +1,99 @@
+package twinkle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"smart-testify/internal/provider"
+	"strings"
+	"testing"
+)
+
+func TestClientChat(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		want     string
+		wantErr  string
+		wantCode int // Status of the HTTPError expected, 0 if none
+	}{
+		{
+			name:     "completion",
+			status:   http.StatusOK,
+			response: `{"completion":"func TestX(t *testing.T) {}"}`,
+			want:     "func TestX(t *testing.T) {}",
+		},
+		{
+			name:     "empty completion",
+			status:   http.StatusOK,
+			response: `{"completion":""}`,
+			wantErr:  "no response received",
+		},
+		{
+			name:     "no completion",
+			status:   http.StatusOK,
+			response: `{}`,
+			wantErr:  "no response received",
+		},
+		{
+			name:     "invalid body",
+			status:   http.StatusOK,
+			response: `oops`,
+			wantErr:  "failed to unmarshal response body",
+		},
+		{
+			name:     "error status",
+			status:   http.StatusBadGateway,
+			response: `upstream down`,
+			wantErr:  "upstream down",
+			wantCode: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Token") != "secret" || r.Header.Get("X-Team") != "qa" {
+					t.Errorf("unexpected headers %v", r.Header)
+				}
+				var req TwinkleRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Prompt != "prompt" {
+					t.Errorf("request = %+v, %v, want the prompt", req, err)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{
+				Endpoint:   server.URL,
+				AuthHeader: "X-Token",
+				AuthToken:  "secret",
+				Headers:    map[string]string{"X-Team": "qa"},
+			})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			resp, err := client.Chat(context.Background(), "prompt")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Chat() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				var httpErr *provider.HTTPError
+				if tt.wantCode != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantCode) {
+					t.Errorf("Chat() error = %v, want an HTTPError with status %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			if resp != tt.want {
+				t.Errorf("Chat() = %q, want %q", resp, tt.want)
+			}
+		})
+	}
+}
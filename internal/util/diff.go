@@ -0,0 +1,177 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around the changes
+const diffContext = 3
+
+type diffOp int
+
+const (
+	opEqual diffOp = iota
+	opDelete
+	opInsert
+)
+
+type diffEdit struct {
+	op   diffOp
+	line string
+}
+
+// UnifiedDiff returns the unified diff between the old and new text, or an empty string if they are equal
+func UnifiedDiff(oldName, newName, oldText, newText string) string {
+	edits := diffLines(splitLines(oldText), splitLines(newText))
+
+	var changes []int
+	for i, edit := range edits {
+		if edit.op != opEqual {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Group the changes which are close enough to share their context in hunks
+	for i := 0; i < len(changes); {
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext {
+			j++
+		}
+		end := changes[j] + diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		writeHunk(&sb, edits, start, end)
+		i = j + 1
+	}
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, edits []diffEdit, start, end int) {
+	// Line numbers of the first line of the hunk in both texts
+	oldLine, newLine := 1, 1
+	for _, edit := range edits[:start] {
+		if edit.op != opInsert {
+			oldLine++
+		}
+		if edit.op != opDelete {
+			newLine++
+		}
+	}
+
+	var oldCount, newCount int
+	var body strings.Builder
+	for _, edit := range edits[start:end] {
+		switch edit.op {
+		case opEqual:
+			oldCount++
+			newCount++
+			body.WriteString(" " + edit.line + "\n")
+		case opDelete:
+			oldCount++
+			body.WriteString("-" + edit.line + "\n")
+		case opInsert:
+			newCount++
+			body.WriteString("+" + edit.line + "\n")
+		}
+	}
+
+	// An empty range starts at the line before it
+	if oldCount == 0 {
+		oldLine--
+	}
+	if newCount == 0 {
+		newLine--
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+	sb.WriteString(body.String())
+}
+
+// diffLines computes the shortest edit script between a and b with the Myers algorithm
+func diffLines(a, b []string) []diffEdit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk back the trace from the end of both texts
+	var edits []diffEdit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, diffEdit{opEqual, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, diffEdit{opInsert, b[y-1]})
+			y--
+		} else {
+			edits = append(edits, diffEdit{opDelete, a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		edits = append(edits, diffEdit{opEqual, a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{
+			name: "empty input",
+		},
+		{
+			name:    "equal texts",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+		},
+		{
+			name:    "inserts into an empty text",
+			newText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "inserts only",
+			oldText: "a\nb\nc\n",
+			newText: "a\nb\nx\ny\nc\n",
+			want:    "--- old\n+++ new\n@@ -1,3 +1,5 @@\n a\n b\n+x\n+y\n c\n",
+		},
+		{
+			name:    "deletes only",
+			oldText: "a\nb\nc\nd\n",
+			newText: "a\nd\n",
+			want:    "--- old\n+++ new\n@@ -1,4 +1,2 @@\n a\n-b\n-c\n d\n",
+		},
+		{
+			name:    "deletes everything",
+			oldText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name:    "mixed hunk",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			newText: "1\n2\n3\nfour\n5\n6\n7\n8\n9\n",
+			want:    "--- old\n+++ new\n@@ -1,8 +1,9 @@\n 1\n 2\n 3\n-4\n+four\n 5\n 6\n 7\n 8\n+9\n",
+		},
+		{
+			name:    "distant changes are split in hunks",
+			oldText: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			newText: "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			want:    "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("old", "new", tt.oldText, tt.newText); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	a := []string{"a", "b", "c", "a", "b", "b", "a"}
+	b := []string{"c", "b", "a", "b", "a", "c"}
+	edits := diffLines(a, b)
+
+	// The classic example of the Myers paper needs 5 edits
+	var changes int
+	var oldLines, newLines []string
+	for _, edit := range edits {
+		switch edit.op {
+		case opEqual:
+			oldLines = append(oldLines, edit.line)
+			newLines = append(newLines, edit.line)
+		case opDelete:
+			changes++
+			oldLines = append(oldLines, edit.line)
+		case opInsert:
+			changes++
+			newLines = append(newLines, edit.line)
+		}
+	}
+	if changes != 5 {
+		t.Errorf("diffLines() made %d edits, want 5", changes)
+	}
+	if strings.Join(oldLines, "") != strings.Join(a, "") || strings.Join(newLines, "") != strings.Join(b, "") {
+		t.Errorf("diffLines() = %v, doesn't turn %v into %v", edits, a, b)
+	}
+}
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"context"
+	"os/exec"
+)
+
+// RunGoCommand runs the go tool in dir and returns its combined output
+func RunGoCommand(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
@@ -1,13 +1,21 @@
 package util
 
 import (
-	"os"
+	"bytes"
+	"fmt"
 	"os/exec"
+	"strings"
 )
 
-func RunGoImports(fileName string) error {
-	cmd := exec.Command("goimports", "-w", fileName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// FormatImports runs goimports on the code, resolving the imports as if it was the given file
+func FormatImports(fileName string, code string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("goimports", "-srcdir", fileName)
+	cmd.Stdin = strings.NewReader(code)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
 }